@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"golang_course/internal/leakcheck"
+)
+
+var ErrBrokerClosed = errors.New("broker is closed")
+
+// SlowConsumerPolicy decides what Publish does
+// when a subscriber buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// Drop discards the message for this subscriber only.
+	Drop SlowConsumerPolicy = iota
+	// Block waits until the subscriber has room,
+	// is cancelled or the broker is closed.
+	Block
+	// Disconnect cancels the subscription and closes its channel.
+	Disconnect
+)
+
+type SubscribeOption func(*subscription)
+
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) SubscribeOption {
+	return func(s *subscription) {
+		s.policy = policy
+	}
+}
+
+type subscription struct {
+	policy SlowConsumerPolicy
+	done   chan struct{}
+	once   sync.Once
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+type subscriber[T any] struct {
+	subscription
+	pattern string
+	ch      chan T
+}
+
+// Broker is an in-process event bus. Topics are dot-separated, subscription
+// patterns may use "*" to match one segment and a trailing ">" to match
+// one or more remaining segments, e.g. "orders.*.created" or "orders.>".
+type Broker[T any] struct {
+	mutex       sync.RWMutex
+	subscribers map[*subscriber[T]]struct{}
+	closed      bool
+	done        chan struct{}
+}
+
+func NewBroker[T any]() *Broker[T] {
+	return &Broker[T]{
+		subscribers: make(map[*subscriber[T]]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (b *Broker[T]) Subscribe(topic string, bufferSize int, options ...SubscribeOption) (<-chan T, func()) {
+	sub := &subscriber[T]{
+		subscription: subscription{policy: Drop, done: make(chan struct{})},
+		pattern:      topic,
+		ch:           make(chan T, bufferSize),
+	}
+	for _, option := range options {
+		option(&sub.subscription)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		sub.close()
+		return sub.ch, func() {}
+	}
+
+	b.subscribers[sub] = struct{}{}
+	return sub.ch, func() { b.unsubscribe(sub) }
+}
+
+func (b *Broker[T]) unsubscribe(sub *subscriber[T]) {
+	b.mutex.Lock()
+	delete(b.subscribers, sub)
+	b.mutex.Unlock()
+
+	sub.close()
+}
+
+func (b *Broker[T]) Publish(topic string, msg T) error {
+	b.mutex.RLock()
+	if b.closed {
+		b.mutex.RUnlock()
+		return ErrBrokerClosed
+	}
+
+	var matched []*subscriber[T]
+	for sub := range b.subscribers {
+		if matchTopic(sub.pattern, topic) {
+			matched = append(matched, sub)
+		}
+	}
+	b.mutex.RUnlock()
+
+	for _, sub := range matched {
+		if !sub.deliver(msg, b.done) {
+			b.unsubscribe(sub)
+		}
+	}
+
+	return nil
+}
+
+// Close cancels all subscriptions, closing their channels,
+// and releases publishers blocked on slow subscribers.
+func (b *Broker[T]) Close() {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return
+	}
+
+	b.closed = true
+	close(b.done)
+	subscribers := b.subscribers
+	b.subscribers = nil
+	b.mutex.Unlock()
+
+	for sub := range subscribers {
+		sub.close()
+	}
+}
+
+// deliver returns false when the subscriber has to be disconnected.
+func (s *subscriber[T]) deliver(msg T, brokerDone <-chan struct{}) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return true
+	}
+
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+		case <-brokerDone:
+		}
+		return true
+	case Disconnect:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *subscriber[T]) close() {
+	s.once.Do(func() {
+		// wake up publishers blocked in deliver before taking the lock
+		close(s.done)
+
+		s.mutex.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mutex.Unlock()
+	})
+}
+
+func matchTopic(pattern, topic string) bool {
+	patternParts := strings.Split(pattern, ".")
+	topicParts := strings.Split(topic, ".")
+
+	for i, part := range patternParts {
+		if part == ">" && i == len(patternParts)-1 {
+			return len(topicParts) > i
+		}
+		if i >= len(topicParts) {
+			return false
+		}
+		if part != "*" && part != topicParts[i] {
+			return false
+		}
+	}
+
+	return len(patternParts) == len(topicParts)
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.deleted", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.created.eu", false},
+		{"*.created", "users.created", true},
+		{"orders.>", "orders.created.eu", true},
+		{"orders.>", "orders", false},
+		{">", "anything.at.all", true},
+		{"orders.>.eu", "orders.created.eu", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.match, matchTopic(test.pattern, test.topic), "%s ~ %s", test.pattern, test.topic)
+	}
+}
+
+func TestBrokerPublishSubscribe(t *testing.T) {
+	broker := NewBroker[string]()
+	defer broker.Close()
+
+	exact, cancelExact := broker.Subscribe("orders.created", 10)
+	wildcard, cancelWildcard := broker.Subscribe("orders.*", 10)
+	defer cancelWildcard()
+
+	assert.NoError(t, broker.Publish("orders.created", "first"))
+	assert.NoError(t, broker.Publish("orders.deleted", "second"))
+	assert.NoError(t, broker.Publish("users.created", "third"))
+
+	assert.Equal(t, "first", <-exact)
+	assert.Equal(t, "first", <-wildcard)
+	assert.Equal(t, "second", <-wildcard)
+
+	cancelExact()
+	cancelExact()
+	_, ok := <-exact
+	assert.False(t, ok)
+	assert.Empty(t, wildcard)
+}
+
+func TestBrokerSlowConsumerPolicies(t *testing.T) {
+	broker := NewBroker[int]()
+	defer broker.Close()
+
+	dropping, cancelDropping := broker.Subscribe("topic", 1, WithSlowConsumerPolicy(Drop))
+	defer cancelDropping()
+	disconnecting, _ := broker.Subscribe("topic", 1, WithSlowConsumerPolicy(Disconnect))
+	blocking, cancelBlocking := broker.Subscribe("topic", 1, WithSlowConsumerPolicy(Block))
+	defer cancelBlocking()
+
+	assert.NoError(t, broker.Publish("topic", 1))
+
+	published := make(chan struct{})
+	go func() {
+		_ = broker.Publish("topic", 2)
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("publish did not block on a full blocking subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(t, 1, <-blocking)
+	<-published
+	assert.Equal(t, 2, <-blocking)
+
+	assert.Equal(t, 1, <-dropping)
+	assert.Empty(t, dropping)
+
+	assert.Equal(t, 1, <-disconnecting)
+	_, ok := <-disconnecting
+	assert.False(t, ok)
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := NewBroker[int]()
+	blocking, _ := broker.Subscribe("topic", 0, WithSlowConsumerPolicy(Block))
+	other, _ := broker.Subscribe(">", 0)
+
+	published := make(chan error)
+	go func() {
+		published <- broker.Publish("topic", 1)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	broker.Close()
+	broker.Close()
+
+	assert.NoError(t, <-published)
+	for range blocking {
+	}
+	for range other {
+	}
+
+	assert.ErrorIs(t, broker.Publish("topic", 2), ErrBrokerClosed)
+	closed, _ := broker.Subscribe("topic", 1)
+	_, ok := <-closed
+	assert.False(t, ok)
+}
+
+func TestBrokerConcurrentPublishUnsubscribe(t *testing.T) {
+	leakcheck.Check(t)
+
+	const publishers, subscribers, messages = 8, 32, 500
+	broker := NewBroker[int]()
+
+	var received atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		policy := SlowConsumerPolicy(i % 3)
+		ch, cancel := broker.Subscribe(fmt.Sprintf("topic.%d", i%4), 4, WithSlowConsumerPolicy(policy))
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for range ch {
+				if received.Add(1)%int64(10+i) == 0 {
+					cancel()
+				}
+			}
+		}(i)
+	}
+
+	var publishWg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		publishWg.Add(1)
+		go func(p int) {
+			defer publishWg.Done()
+			for m := 0; m < messages; m++ {
+				_ = broker.Publish(fmt.Sprintf("topic.%d", (p+m)%4), m)
+			}
+		}(p)
+	}
+
+	publishWg.Wait()
+	broker.Close()
+	wg.Wait()
+
+	assert.Positive(t, received.Load())
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -8,27 +11,316 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-// go test -v homework_test.go
+// go test -v .
+
+const queueSizePerWorker = 32
+
+var (
+	ErrPoolFull   = errors.New("worker pool is full")
+	ErrPoolClosed = errors.New("worker pool is closed")
+	ErrTaskPanic  = errors.New("task panicked")
+)
+
+type Option func(*WorkerPool)
+
+func WithHooks(hooks Hooks) Option {
+	return func(wp *WorkerPool) {
+		wp.hooks = hooks
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(wp *WorkerPool) {
+		wp.clock = clock
+	}
+}
+
+type queuedTask struct {
+	run        func() error
+	key        string
+	keyed      bool
+	enqueuedAt time.Time
+}
 
 type WorkerPool struct {
-	// need to implement
+	tasks chan queuedTask
+	wg    sync.WaitGroup
+
+	mutex  sync.RWMutex
+	closed bool
+	// done is closed by Shutdown to cut retry backoffs short
+	done chan struct{}
+
+	keyedMutex sync.Mutex
+	keyed      map[string][]queuedTask
+	// keyedPending counts tasks waiting in the per-key queues,
+	// they share the pool capacity with the tasks in the channel
+	keyedPending atomic.Int64
+
+	hooks   Hooks
+	metrics metrics
+	clock   Clock
+	limiter *tokenBucket
+	retry   *RetryPolicy
+}
+
+func NewWorkerPool(workersNumber int, options ...Option) *WorkerPool {
+	wp := &WorkerPool{
+		tasks:   make(chan queuedTask, workersNumber*queueSizePerWorker),
+		keyed:   make(map[string][]queuedTask),
+		done:    make(chan struct{}),
+		metrics: newMetrics(),
+		clock:   realClock{},
+	}
+
+	for _, option := range options {
+		option(wp)
+	}
+	if wp.limiter != nil {
+		wp.limiter.start(wp.clock)
+	}
+
+	wp.wg.Add(workersNumber)
+	for i := 0; i < workersNumber; i++ {
+		go wp.worker()
+	}
+
+	return wp
+}
+
+func (wp *WorkerPool) worker() {
+	defer wp.wg.Done()
+	for task := range wp.tasks {
+		wp.process(task)
+	}
+}
+
+// process runs the task and, for keyed tasks, keeps running the
+// following tasks of the same key that could not be put back in the queue.
+func (wp *WorkerPool) process(task queuedTask) {
+	for {
+		wp.execute(task)
+		if !task.keyed {
+			return
+		}
+
+		next, ok := wp.nextKeyed(task.key)
+		if !ok {
+			return
+		}
+		task = next
+	}
 }
 
-func NewWorkerPool(workersNumber int) *WorkerPool {
-	// need to implement
-	return &WorkerPool{}
+func (wp *WorkerPool) execute(task queuedTask) {
+	info := TaskInfo{Key: task.key, QueueWait: wp.clock.Now().Sub(task.enqueuedAt)}
+	wp.metrics.queued.Add(-1)
+	wp.metrics.running.Add(1)
+	wp.metrics.queueWait.Observe(info.QueueWait)
+	if wp.hooks.OnTaskStart != nil {
+		wp.hooks.OnTaskStart(info)
+	}
+
+	runTime, err := wp.runAttempts(task.run)
+
+	wp.metrics.runTime.Observe(runTime)
+	wp.metrics.running.Add(-1)
+	if err != nil {
+		wp.metrics.failed.Add(1)
+	} else {
+		wp.metrics.completed.Add(1)
+	}
+	if wp.hooks.OnTaskEnd != nil {
+		wp.hooks.OnTaskEnd(info, runTime, err)
+	}
+}
+
+// runAttempts runs the task respecting the rate limit and retries it
+// according to the retry policy. It returns the time spent in the task
+// itself, without the waits for the limiter and the backoffs.
+//
+// The worker waits out the backoff itself, so a long backoff holds it.
+// Shutdown ends the backoffs that are in progress when it is called and
+// those tasks fail with the error of their last attempt, while the tasks
+// that run after it still get all their attempts.
+func (wp *WorkerPool) runAttempts(task func() error) (time.Duration, error) {
+	var runTime time.Duration
+	for attempt := 1; ; attempt++ {
+		if wp.limiter != nil {
+			<-wp.clock.After(wp.limiter.reserve())
+		}
+
+		start := wp.clock.Now()
+		err := runSafely(task)
+		runTime += wp.clock.Now().Sub(start)
+		if err == nil || !wp.retry.shouldRetry(err, attempt) {
+			return runTime, err
+		}
+
+		backoff := wp.clock.After(wp.retry.backoff(attempt))
+		select {
+		case <-wp.done:
+			<-backoff
+			continue
+		default:
+		}
+
+		select {
+		case <-backoff:
+		case <-wp.done:
+			// a backoff that has already elapsed is not cut short
+			select {
+			case <-backoff:
+			default:
+				return runTime, err
+			}
+		}
+	}
+}
+
+func runSafely(task func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrTaskPanic, r)
+		}
+	}()
+
+	return task()
 }
 
 // Return an error if the pool is full
 func (wp *WorkerPool) AddTask(task func()) error {
-	// need to implement
+	return wp.submit(queuedTask{run: noError(task)})
+}
+
+// AddTaskWithError accepts a task whose error is retried
+// according to the pool retry policy and reported as a failure.
+func (wp *WorkerPool) AddTaskWithError(task func() error) error {
+	return wp.submit(queuedTask{run: task})
+}
+
+func noError(task func()) func() error {
+	return func() error {
+		task()
+		return nil
+	}
+}
+
+// SubmitKeyed runs tasks sharing the same key one at a time in submission
+// order, while tasks with different keys are spread across workers.
+// Only the first task of an idle key goes to the pool queue, later ones
+// wait in the per-key queue until their predecessor completes, but still
+// count against the pool capacity.
+func (wp *WorkerPool) SubmitKeyed(key string, task func()) error {
+	return wp.submit(queuedTask{run: noError(task), key: key, keyed: true})
+}
+
+func (wp *WorkerPool) submit(task queuedTask) error {
+	err := wp.enqueue(task)
+	if err != nil {
+		wp.metrics.rejected.Add(1)
+		if wp.hooks.OnReject != nil {
+			wp.hooks.OnReject(err)
+		}
+	}
+
+	return err
+}
+
+func (wp *WorkerPool) enqueue(task queuedTask) error {
+	wp.mutex.RLock()
+	defer wp.mutex.RUnlock()
+
+	if wp.closed {
+		return ErrPoolClosed
+	}
+
+	task.enqueuedAt = wp.clock.Now()
+	if !task.keyed {
+		return wp.push(task)
+	}
+
+	wp.keyedMutex.Lock()
+	defer wp.keyedMutex.Unlock()
+
+	if pending, active := wp.keyed[task.key]; active {
+		if wp.full() {
+			return ErrPoolFull
+		}
+		wp.keyedPending.Add(1)
+		wp.metrics.queued.Add(1)
+		wp.keyed[task.key] = append(pending, task)
+		return nil
+	}
+
+	if err := wp.push(task); err != nil {
+		return err
+	}
+	wp.keyed[task.key] = nil
 	return nil
 }
 
+func (wp *WorkerPool) full() bool {
+	return len(wp.tasks)+int(wp.keyedPending.Load()) >= cap(wp.tasks)
+}
+
+func (wp *WorkerPool) push(task queuedTask) error {
+	if wp.full() {
+		return ErrPoolFull
+	}
+
+	select {
+	case wp.tasks <- task:
+		wp.metrics.queued.Add(1)
+		return nil
+	default:
+		return ErrPoolFull
+	}
+}
+
+// nextKeyed pops the next task of the key and puts it back into the pool
+// queue so that a busy key does not occupy a worker. It returns the task
+// to be run in place when the queue is full or the pool is shutting down.
+func (wp *WorkerPool) nextKeyed(key string) (queuedTask, bool) {
+	wp.keyedMutex.Lock()
+	pending := wp.keyed[key]
+	if len(pending) == 0 {
+		delete(wp.keyed, key)
+		wp.keyedMutex.Unlock()
+		return queuedTask{}, false
+	}
+
+	next := pending[0]
+	wp.keyed[key] = pending[1:]
+	wp.keyedPending.Add(-1)
+	wp.keyedMutex.Unlock()
+
+	wp.mutex.RLock()
+	defer wp.mutex.RUnlock()
+
+	if !wp.closed {
+		select {
+		case wp.tasks <- next:
+			return queuedTask{}, false
+		default:
+		}
+	}
+
+	return next, true
+}
+
 // Shutdown all workers and wait for all
 // tasks in the pool to complete
 func (wp *WorkerPool) Shutdown() {
-	// need to implement
+	wp.mutex.Lock()
+	if !wp.closed {
+		wp.closed = true
+		close(wp.tasks)
+		close(wp.done)
+	}
+	wp.mutex.Unlock()
+
+	wp.wg.Wait()
 }
 
 func TestWorkerPool(t *testing.T) {
@@ -56,3 +348,103 @@ func TestWorkerPool(t *testing.T) {
 
 	assert.Equal(t, int32(6), counter.Load())
 }
+
+func TestWorkerPoolAddTaskAfterShutdown(t *testing.T) {
+	pool := NewWorkerPool(1)
+	pool.Shutdown()
+
+	assert.ErrorIs(t, pool.AddTask(func() {}), ErrPoolClosed)
+	assert.ErrorIs(t, pool.SubmitKeyed("key", func() {}), ErrPoolClosed)
+}
+
+func TestWorkerPoolFull(t *testing.T) {
+	release := make(chan struct{})
+	pool := NewWorkerPool(1)
+
+	var err error
+	for err == nil {
+		err = pool.AddTask(func() { <-release })
+	}
+	assert.ErrorIs(t, err, ErrPoolFull)
+
+	close(release)
+	pool.Shutdown()
+}
+
+func TestWorkerPoolSubmitKeyedOrder(t *testing.T) {
+	const keys, tasksPerKey = 4, 25
+
+	var mutex sync.Mutex
+	executed := make(map[string][]int)
+	running := make(map[string]*atomic.Int32)
+	for k := 0; k < keys; k++ {
+		running[fmt.Sprint(k)] = new(atomic.Int32)
+	}
+
+	pool := NewWorkerPool(4)
+	for i := 0; i < tasksPerKey; i++ {
+		for k := 0; k < keys; k++ {
+			key, idx := fmt.Sprint(k), i
+			err := pool.SubmitKeyed(key, func() {
+				assert.Equal(t, int32(1), running[key].Add(1))
+				time.Sleep(time.Millisecond)
+
+				mutex.Lock()
+				executed[key] = append(executed[key], idx)
+				mutex.Unlock()
+
+				running[key].Add(-1)
+			})
+			assert.NoError(t, err)
+		}
+	}
+	pool.Shutdown()
+
+	for k := 0; k < keys; k++ {
+		order := executed[fmt.Sprint(k)]
+		assert.Len(t, order, tasksPerKey)
+		for i := range order {
+			assert.Equal(t, i, order[i])
+		}
+	}
+}
+
+func TestWorkerPoolSubmitKeyedFull(t *testing.T) {
+	release := make(chan struct{})
+	pool := NewWorkerPool(1)
+
+	var accepted int
+	var err error
+	for err == nil {
+		if err = pool.SubmitKeyed("hot", func() { <-release }); err == nil {
+			accepted++
+		}
+	}
+	assert.ErrorIs(t, err, ErrPoolFull)
+	// the first task may already be running
+	assert.LessOrEqual(t, accepted, queueSizePerWorker+1)
+	assert.ErrorIs(t, pool.AddTask(func() {}), ErrPoolFull)
+
+	close(release)
+	pool.Shutdown()
+}
+
+func TestWorkerPoolSubmitKeyedNoHeadOfLineBlocking(t *testing.T) {
+	release := make(chan struct{})
+	pool := NewWorkerPool(2)
+
+	_ = pool.SubmitKeyed("slow", func() { <-release })
+	_ = pool.SubmitKeyed("slow", func() {})
+
+	done := make(chan struct{})
+	_ = pool.SubmitKeyed("fast", func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task with another key is blocked by a busy key")
+	}
+
+	close(release)
+	pool.Shutdown()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var defaultBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+type TaskInfo struct {
+	Key       string
+	QueueWait time.Duration
+}
+
+// Hooks are called synchronously from the worker or the submitting
+// goroutine, so they must be cheap and safe for concurrent use.
+type Hooks struct {
+	OnTaskStart func(info TaskInfo)
+	OnTaskEnd   func(info TaskInfo, runTime time.Duration, err error)
+	OnReject    func(err error)
+}
+
+type Stats struct {
+	Queued    int64
+	Running   int64
+	Completed uint64
+	Failed    uint64
+	Rejected  uint64
+	QueueWait HistogramSnapshot
+	RunTime   HistogramSnapshot
+}
+
+type metrics struct {
+	queued    atomic.Int64
+	running   atomic.Int64
+	completed atomic.Uint64
+	failed    atomic.Uint64
+	rejected  atomic.Uint64
+	queueWait *histogram
+	runTime   *histogram
+}
+
+func newMetrics() metrics {
+	return metrics{
+		queueWait: newHistogram(defaultBuckets),
+		runTime:   newHistogram(defaultBuckets),
+	}
+}
+
+func (wp *WorkerPool) Stats() Stats {
+	return Stats{
+		Queued:    wp.metrics.queued.Load(),
+		Running:   wp.metrics.running.Load(),
+		Completed: wp.metrics.completed.Load(),
+		Failed:    wp.metrics.failed.Load(),
+		Rejected:  wp.metrics.rejected.Load(),
+		QueueWait: wp.metrics.queueWait.Snapshot(),
+		RunTime:   wp.metrics.runTime.Snapshot(),
+	}
+}
+
+// PublishExpvar exposes the pool stats under the given name,
+// expvar panics if the name is already registered.
+func PublishExpvar(name string, wp *WorkerPool) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return wp.Stats()
+	}))
+}
+
+// HistogramSnapshot holds per-bucket (not cumulative) counts: Counts[i] is
+// the number of observations in (Bounds[i-1], Bounds[i]], the last element
+// of Counts is the overflow bucket.
+type HistogramSnapshot struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+type histogram struct {
+	bounds []time.Duration
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) Observe(value time.Duration) {
+	idx := len(h.bounds)
+	for i, bound := range h.bounds {
+		if value <= bound {
+			idx = i
+			break
+		}
+	}
+
+	h.counts[idx].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(value))
+}
+
+func (h *histogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		snapshot.Counts[i] = h.counts[i].Load()
+	}
+
+	return snapshot
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]time.Duration{time.Millisecond, time.Second})
+	h.Observe(time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(500 * time.Millisecond)
+	h.Observe(time.Minute)
+
+	snapshot := h.Snapshot()
+	assert.Equal(t, []uint64{2, 1, 1}, snapshot.Counts)
+	assert.Equal(t, uint64(4), snapshot.Count)
+	assert.Equal(t, time.Minute+501*time.Millisecond+time.Microsecond, snapshot.Sum)
+}
+
+func TestWorkerPoolStats(t *testing.T) {
+	release := make(chan struct{})
+	pool := NewWorkerPool(1)
+
+	_ = pool.AddTask(func() { <-release })
+	_ = pool.AddTask(func() { panic("boom") })
+	_ = pool.AddTask(func() {})
+
+	assert.Eventually(t, func() bool {
+		return pool.Stats().Running == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), pool.Stats().Queued)
+
+	close(release)
+	pool.Shutdown()
+	_ = pool.AddTask(func() {})
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(0), stats.Queued)
+	assert.Equal(t, int64(0), stats.Running)
+	assert.Equal(t, uint64(2), stats.Completed)
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, uint64(3), stats.QueueWait.Count)
+	assert.Equal(t, uint64(3), stats.RunTime.Count)
+}
+
+func TestWorkerPoolHooks(t *testing.T) {
+	var mutex sync.Mutex
+	var started, ended []string
+	var endErrors []error
+	var rejected []error
+
+	pool := NewWorkerPool(1, WithHooks(Hooks{
+		OnTaskStart: func(info TaskInfo) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			started = append(started, info.Key)
+		},
+		OnTaskEnd: func(info TaskInfo, _ time.Duration, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			ended = append(ended, info.Key)
+			endErrors = append(endErrors, err)
+		},
+		OnReject: func(err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			rejected = append(rejected, err)
+		},
+	}))
+
+	_ = pool.SubmitKeyed("a", func() {})
+	_ = pool.SubmitKeyed("a", func() { panic("boom") })
+	pool.Shutdown()
+	_ = pool.SubmitKeyed("a", func() {})
+
+	assert.Equal(t, []string{"a", "a"}, started)
+	assert.Equal(t, []string{"a", "a"}, ended)
+	assert.NoError(t, endErrors[0])
+	assert.True(t, errors.Is(endErrors[1], ErrTaskPanic))
+	assert.Equal(t, []error{ErrPoolClosed}, rejected)
+}
+
+func TestPublishExpvar(t *testing.T) {
+	pool := NewWorkerPool(2)
+	name := fmt.Sprintf("worker_pool_%p", pool)
+	PublishExpvar(name, pool)
+
+	_ = pool.AddTask(func() {})
+	pool.Shutdown()
+
+	var stats Stats
+	err := json.Unmarshal([]byte(expvar.Get(name).String()), &stats)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), stats.Completed)
+}
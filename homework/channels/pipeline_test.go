@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"golang_course/internal/leakcheck"
+)
+
+// Every stage closes its output once the input is drained or the context
+// is cancelled, so abandoning a pipeline only requires cancelling ctx.
+
+func Generate[T any](ctx context.Context, values ...T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for _, value := range values {
+			select {
+			case out <- value:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// OrDone lets a consumer range over a channel it does not own
+// without getting stuck when ctx is cancelled.
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case value, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- value:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// FanOut spreads values from in across n outputs,
+// each value is delivered to exactly one of them.
+func FanOut[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	outs := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = OrDone(ctx, in)
+	}
+
+	return outs
+}
+
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	done := make(chan struct{})
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer func() { done <- struct{}{} }()
+			for value := range OrDone(ctx, in) {
+				select {
+				case out <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(in)
+	}
+
+	go func() {
+		for range ins {
+			<-done
+		}
+		close(out)
+	}()
+
+	return out
+}
+
+func FanIn[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	return Merge(ctx, ins...)
+}
+
+// Tee duplicates every value to both outputs,
+// the slower reader holds back the faster one.
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	out1, out2 := make(chan T), make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for value := range OrDone(ctx, in) {
+			first, second := out1, out2
+			for i := 0; i < 2; i++ {
+				select {
+				case first <- value:
+					first = nil
+				case second <- value:
+					second = nil
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out1, out2
+}
+
+// Batch groups values into slices of up to size elements, a partial
+// batch is emitted once maxWait has passed since its first element.
+func Batch[T any](ctx context.Context, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+
+		var batch []T
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+
+			select {
+			case out <- batch:
+				batch = nil
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case value, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+
+				batch = append(batch, value)
+				if len(batch) == 1 {
+					timer = time.NewTimer(maxWait)
+					timeout = timer.C
+				}
+				if len(batch) == size && !flush() {
+					return
+				}
+			case <-timeout:
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Throttle passes at most one value per interval, a non-positive
+// interval passes values through as they come.
+func Throttle[T any](ctx context.Context, in <-chan T, interval time.Duration) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+
+		for value := range OrDone(ctx, in) {
+			select {
+			case out <- value:
+			case <-ctx.Done():
+				return
+			}
+			if interval <= 0 {
+				continue
+			}
+
+			// the interval is counted from the send, so time spent
+			// waiting for the input does not shorten the next one
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Bridge flattens a sequence of channels into a single channel,
+// reading each of them to the end before moving to the next one.
+func Bridge[T any](ctx context.Context, chans <-chan (<-chan T)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for in := range OrDone(ctx, chans) {
+			for value := range OrDone(ctx, in) {
+				select {
+				case out <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func collect[T any](in <-chan T) []T {
+	var values []T
+	for value := range in {
+		values = append(values, value)
+	}
+	return values
+}
+
+func TestGenerate(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+
+	assert.Equal(t, []int{1, 2, 3}, collect(Generate(ctx, 1, 2, 3)))
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+
+	outs := FanOut(ctx, Generate(ctx, 1, 2, 3, 4, 5, 6, 7, 8), 3)
+	values := collect(FanIn(ctx, outs...))
+
+	sort.Ints(values)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, values)
+}
+
+func TestTee(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+
+	out1, out2 := Tee(ctx, Generate(ctx, 1, 2, 3))
+	var values1, values2 []int
+	for i := 0; i < 3; i++ {
+		values1 = append(values1, <-out1)
+		values2 = append(values2, <-out2)
+	}
+
+	assert.Equal(t, []int{1, 2, 3}, values1)
+	assert.Equal(t, []int{1, 2, 3}, values2)
+}
+
+func TestBatch(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+
+	batches := collect(Batch(ctx, Generate(ctx, 1, 2, 3, 4, 5), 2, time.Second))
+	assert.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, batches)
+
+	in := make(chan int)
+	out := Batch(ctx, in, 10, 50*time.Millisecond)
+	in <- 1
+	in <- 2
+
+	start := time.Now()
+	assert.Equal(t, []int{1, 2}, <-out)
+	assert.Less(t, time.Since(start), time.Second)
+
+	close(in)
+	_, ok := <-out
+	assert.False(t, ok)
+}
+
+func TestThrottle(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	values := collect(Throttle(ctx, Generate(ctx, 1, 2, 3), 50*time.Millisecond))
+
+	assert.Equal(t, []int{1, 2, 3}, values)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, []int{1, 2, 3}, collect(Throttle(ctx, Generate(ctx, 1, 2, 3), 0)))
+}
+
+func TestThrottleAfterIdle(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+	const interval = 50 * time.Millisecond
+
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		in <- 1
+		time.Sleep(3 * interval)
+		in <- 2
+		in <- 3
+	}()
+
+	var received []time.Time
+	for range Throttle(ctx, in, interval) {
+		received = append(received, time.Now())
+	}
+
+	assert.Len(t, received, 3)
+	for i := 1; i < len(received); i++ {
+		// the timestamps are taken by the reader, allow for its scheduling
+		assert.GreaterOrEqual(t, received[i].Sub(received[i-1]), interval*9/10)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	leakcheck.Check(t)
+	ctx := context.Background()
+
+	chans := make(chan (<-chan int), 3)
+	chans <- Generate(ctx, 1, 2)
+	chans <- Generate(ctx, 3)
+	chans <- Generate(ctx, 4, 5)
+	close(chans)
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, collect(Bridge(ctx, chans)))
+}
+
+func TestPipelineCancellation(t *testing.T) {
+	leakcheck.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	infinite := make(chan int)
+	defer close(infinite)
+
+	values := Generate(ctx, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	out1, out2 := Tee(ctx, Merge(ctx, append(FanOut(ctx, values, 3), OrDone(ctx, infinite))...))
+	batches := Batch(ctx, Throttle(ctx, out1, time.Millisecond), 3, time.Second)
+
+	chans := make(chan (<-chan int))
+	bridged := Bridge(ctx, chans)
+	go func() {
+		select {
+		case chans <- out2:
+		case <-ctx.Done():
+		}
+	}()
+
+	<-batches
+	<-bridged
+	cancel()
+
+	for range batches {
+	}
+	for range bridged {
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock only moves when Advance is called, which lets tests
+// step through rate limits and backoffs without sleeping.
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			waiters = append(waiters, waiter)
+			continue
+		}
+		waiter.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// Waiters returns the number of pending After calls.
+func (c *FakeClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+// WithRateLimit limits task starts, including retries,
+// to rate per second with bursts of up to burst tasks.
+// It panics if rate is not positive.
+func WithRateLimit(rate float64, burst int) Option {
+	if !(rate > 0) {
+		panic("worker pool: non-positive rate limit")
+	}
+	return func(wp *WorkerPool) {
+		wp.limiter = &tokenBucket{rate: rate, burst: float64(burst)}
+	}
+}
+
+func WithRetry(policy RetryPolicy) Option {
+	return func(wp *WorkerPool) {
+		wp.retry = &policy
+	}
+}
+
+type tokenBucket struct {
+	mutex  sync.Mutex
+	clock  Clock
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) start(clock Clock) {
+	b.clock = clock
+	b.tokens = b.burst
+	b.last = clock.Now()
+}
+
+// reserve takes a token, possibly borrowing it from the future,
+// and returns how long the caller has to wait before using it.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.clock.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff that is randomized, in [0, 1].
+	Jitter float64
+	// Retryable reports whether the error is worth another attempt,
+	// nil means every error except a panic is retried.
+	Retryable func(error) bool
+}
+
+func (p *RetryPolicy) shouldRetry(err error, attempt int) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !errors.Is(err, ErrTaskPanic)
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		delay = math.Min(delay, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		delay *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, time.Second, policy.backoff(5))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.backoff(1)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+		assert.LessOrEqual(t, delay, 150*time.Millisecond)
+	}
+}
+
+func TestWorkerPoolRateLimit(t *testing.T) {
+	var counter atomic.Int32
+	clock := NewFakeClock(time.Now())
+	pool := NewWorkerPool(1, WithClock(clock), WithRateLimit(10, 2))
+
+	for i := 0; i < 4; i++ {
+		_ = pool.AddTask(func() { counter.Add(1) })
+	}
+
+	assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), counter.Load())
+
+	clock.Advance(99 * time.Millisecond)
+	assert.Equal(t, int32(2), counter.Load())
+
+	clock.Advance(time.Millisecond)
+	assert.Eventually(t, func() bool { return counter.Load() == 3 && clock.Waiters() == 1 }, time.Second, time.Millisecond)
+
+	clock.Advance(100 * time.Millisecond)
+	pool.Shutdown()
+	assert.Equal(t, int32(4), counter.Load())
+}
+
+func TestWorkerPoolRetry(t *testing.T) {
+	errTemporary := errors.New("temporary")
+	errPermanent := errors.New("permanent")
+
+	clock := NewFakeClock(time.Now())
+	pool := NewWorkerPool(1, WithClock(clock), WithRetry(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		Multiplier:     2,
+		Retryable: func(err error) bool {
+			return errors.Is(err, errTemporary)
+		},
+	}))
+
+	var attempts atomic.Int32
+	_ = pool.AddTaskWithError(func() error {
+		if attempts.Add(1) < 3 {
+			return errTemporary
+		}
+		return nil
+	})
+
+	assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return attempts.Load() == 2 && clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	clock.Advance(2 * time.Second)
+	assert.Eventually(t, func() bool { return pool.Stats().Completed == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(3), attempts.Load())
+
+	var permanentAttempts atomic.Int32
+	_ = pool.AddTaskWithError(func() error {
+		permanentAttempts.Add(1)
+		return errPermanent
+	})
+	pool.Shutdown()
+
+	assert.Equal(t, int32(1), permanentAttempts.Load())
+	assert.Equal(t, uint64(1), pool.Stats().Failed)
+}
+
+func TestWorkerPoolRetryExhausted(t *testing.T) {
+	var lastErr atomic.Value
+	var runTime atomic.Int64
+	clock := NewFakeClock(time.Now())
+	pool := NewWorkerPool(1,
+		WithClock(clock),
+		WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second}),
+		WithHooks(Hooks{OnTaskEnd: func(_ TaskInfo, duration time.Duration, err error) {
+			lastErr.Store(err)
+			runTime.Store(int64(duration))
+		}}),
+	)
+
+	errFailed := errors.New("failed")
+	var attempts atomic.Int32
+	_ = pool.AddTaskWithError(func() error {
+		attempts.Add(1)
+		return errFailed
+	})
+
+	assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	pool.Shutdown()
+
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Equal(t, uint64(1), pool.Stats().Failed)
+	assert.Equal(t, errFailed, lastErr.Load())
+	// the backoff is not counted as run time
+	assert.Equal(t, int64(0), runTime.Load())
+}
+
+func TestWorkerPoolRetryAfterShutdown(t *testing.T) {
+	release := make(chan struct{})
+	pool := NewWorkerPool(1, WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	_ = pool.AddTask(func() { <-release })
+
+	var attempts atomic.Int32
+	_ = pool.AddTaskWithError(func() error {
+		if attempts.Add(1) < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+
+	shutdown := make(chan struct{})
+	go func() {
+		pool.Shutdown()
+		close(shutdown)
+	}()
+	<-pool.done
+	close(release)
+	<-shutdown
+
+	assert.Equal(t, int32(3), attempts.Load())
+	assert.Equal(t, uint64(0), pool.Stats().Failed)
+}
+
+func TestWithRateLimitInvalid(t *testing.T) {
+	assert.Panics(t, func() { WithRateLimit(0, 1) })
+	assert.Panics(t, func() { WithRateLimit(-1, 1) })
+}
+
+func TestWorkerPoolShutdownDuringBackoff(t *testing.T) {
+	var lastErr atomic.Value
+	clock := NewFakeClock(time.Now())
+	pool := NewWorkerPool(1,
+		WithClock(clock),
+		WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}),
+		WithHooks(Hooks{OnTaskEnd: func(_ TaskInfo, _ time.Duration, err error) {
+			lastErr.Store(err)
+		}}),
+	)
+
+	errFailed := errors.New("failed")
+	var attempts atomic.Int32
+	_ = pool.AddTaskWithError(func() error {
+		attempts.Add(1)
+		return errFailed
+	})
+
+	assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		pool.Shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waits for the retry backoff")
+	}
+	assert.Equal(t, int32(1), attempts.Load())
+	assert.Equal(t, uint64(1), pool.Stats().Failed)
+	assert.Equal(t, errFailed, lastErr.Load())
+}
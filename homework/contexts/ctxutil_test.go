@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"golang_course/internal/leakcheck"
+)
+
+// Detach returns a context that keeps the values of ctx but is never
+// cancelled by it, e.g. for work that must outlive a request.
+func Detach(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// DetachWithTimeout is like Detach, but bounds the detached work by timeout.
+func DetachWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+type mergedContext struct {
+	context.Context
+	second context.Context
+}
+
+func (c *mergedContext) Value(key any) any {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+	return c.second.Value(key)
+}
+
+// Merge returns a context that is done as soon as either ctx1 or ctx2 is,
+// has the earlier of their deadlines and looks up values in ctx1 first.
+// The cancel function must be called to release the watch on ctx2.
+func Merge(ctx1, ctx2 context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx1)
+	cancelDeadline := context.CancelFunc(func() {})
+	if deadline, ok := ctx2.Deadline(); ok {
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+	}
+
+	stop := context.AfterFunc(ctx2, func() {
+		cancel(context.Cause(ctx2))
+	})
+
+	return &mergedContext{Context: ctx, second: ctx2}, func() {
+		stop()
+		cancelDeadline()
+		cancel(context.Canceled)
+	}
+}
+
+// Budget splits the time left until the parent deadline between
+// sequential steps proportionally to their weights. Time not used
+// by a step is shared among the following ones.
+type Budget struct {
+	ctx     context.Context
+	weights []float64
+	now     func() time.Time
+}
+
+func WithBudget(ctx context.Context, weights ...float64) *Budget {
+	return &Budget{ctx: ctx, weights: weights, now: time.Now}
+}
+
+// Next returns the context for the next step, after the last step it
+// falls back to the parent deadline. Without a parent deadline steps are
+// only bounded by the parent cancellation.
+func (b *Budget) Next() (context.Context, context.CancelFunc) {
+	deadline, ok := b.ctx.Deadline()
+	if !ok || len(b.weights) == 0 {
+		return context.WithCancel(b.ctx)
+	}
+
+	var total float64
+	for _, weight := range b.weights {
+		total += weight
+	}
+
+	share := b.weights[0] / total
+	b.weights = b.weights[1:]
+
+	now := b.now()
+	stepBudget := time.Duration(float64(deadline.Sub(now)) * share)
+	return context.WithDeadline(b.ctx, now.Add(stepBudget))
+}
+
+// CloseOnDone closes the closer when ctx is done, which unblocks I/O
+// that does not accept a context. The returned function stops the watch
+// and reports whether the closer was left open.
+func CloseOnDone(ctx context.Context, closer io.Closer) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		_ = closer.Close()
+	})
+}
+
+// CleanupOnDone runs cleanup once ctx is done, passing it a detached
+// context with the given timeout so the cleanup itself is not cancelled.
+func CleanupOnDone(ctx context.Context, timeout time.Duration, cleanup func(ctx context.Context)) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		cleanupCtx, cancel := DetachWithTimeout(ctx, timeout)
+		defer cancel()
+		cleanup(cleanupCtx)
+	})
+}
+
+type contextKey string
+
+func TestDetach(t *testing.T) {
+	leakcheck.Check(t)
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey("user"), "alice"))
+	detached := Detach(parent)
+	bounded, cancelBounded := DetachWithTimeout(parent, time.Hour)
+	defer cancelBounded()
+	cancel()
+
+	assert.NoError(t, detached.Err())
+	assert.NoError(t, bounded.Err())
+	assert.Equal(t, "alice", detached.Value(contextKey("user")))
+	assert.Equal(t, "alice", bounded.Value(contextKey("user")))
+
+	_, ok := bounded.Deadline()
+	assert.True(t, ok)
+}
+
+func TestMerge(t *testing.T) {
+	leakcheck.Check(t)
+	errStopped := errors.New("stopped")
+
+	ctx1 := context.WithValue(context.Background(), contextKey("a"), 1)
+	ctx2, cancel2 := context.WithCancelCause(context.WithValue(context.Background(), contextKey("b"), 2))
+
+	merged, cancel := Merge(ctx1, ctx2)
+	defer cancel()
+
+	assert.Equal(t, 1, merged.Value(contextKey("a")))
+	assert.Equal(t, 2, merged.Value(contextKey("b")))
+	assert.NoError(t, merged.Err())
+
+	cancel2(errStopped)
+	<-merged.Done()
+	assert.ErrorIs(t, merged.Err(), context.Canceled)
+	assert.ErrorIs(t, context.Cause(merged), errStopped)
+}
+
+func TestMergeDeadline(t *testing.T) {
+	leakcheck.Check(t)
+
+	ctx1, cancel1 := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel1()
+	deadline := time.Now().Add(time.Minute)
+	ctx2, cancel2 := context.WithDeadline(context.Background(), deadline)
+	defer cancel2()
+
+	merged, cancel := Merge(ctx1, ctx2)
+	mergedDeadline, ok := merged.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, deadline, mergedDeadline)
+
+	cancel()
+	assert.ErrorIs(t, merged.Err(), context.Canceled)
+	assert.NoError(t, ctx2.Err())
+}
+
+func TestBudget(t *testing.T) {
+	leakcheck.Check(t)
+
+	now := time.Now()
+	parent, cancel := context.WithDeadline(context.Background(), now.Add(10*time.Second))
+	defer cancel()
+
+	budget := WithBudget(parent, 1, 3, 1)
+	budget.now = func() time.Time { return now }
+
+	step, cancelStep := budget.Next()
+	deadline, _ := step.Deadline()
+	assert.Equal(t, now.Add(2*time.Second), deadline)
+	cancelStep()
+
+	// the first step finished early, its leftover goes to the rest
+	now = now.Add(time.Second)
+	step, cancelStep = budget.Next()
+	deadline, _ = step.Deadline()
+	assert.Equal(t, now.Add(6750*time.Millisecond), deadline)
+	cancelStep()
+
+	now = now.Add(6750 * time.Millisecond)
+	step, cancelStep = budget.Next()
+	deadline, _ = step.Deadline()
+	assert.Equal(t, now.Add(2250*time.Millisecond), deadline)
+	cancelStep()
+
+	step, cancelStep = budget.Next()
+	deadline, _ = step.Deadline()
+	assert.Equal(t, now.Add(2250*time.Millisecond), deadline)
+	cancelStep()
+}
+
+func TestBudgetWithoutDeadline(t *testing.T) {
+	step, cancel := WithBudget(context.Background(), 1, 1).Next()
+	defer cancel()
+
+	_, ok := step.Deadline()
+	assert.False(t, ok)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func TestCloseOnDone(t *testing.T) {
+	leakcheck.Check(t)
+
+	closed := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	CloseOnDone(ctx, closerFunc(func() error {
+		close(closed)
+		return nil
+	}))
+
+	cancel()
+	<-closed
+
+	var closedCount atomic.Int32
+	ctx, cancel = context.WithCancel(context.Background())
+	stop := CloseOnDone(ctx, closerFunc(func() error {
+		closedCount.Add(1)
+		return nil
+	}))
+	assert.True(t, stop())
+	cancel()
+	assert.Equal(t, int32(0), closedCount.Load())
+}
+
+func TestCleanupOnDone(t *testing.T) {
+	leakcheck.Check(t)
+
+	cleaned := make(chan error)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey("id"), 7))
+	CleanupOnDone(ctx, time.Second, func(ctx context.Context) {
+		assert.Equal(t, 7, ctx.Value(contextKey("id")))
+		cleaned <- ctx.Err()
+	})
+
+	cancel()
+	assert.NoError(t, <-cleaned)
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -10,22 +13,201 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+type Option func(*Group)
+
+// WithAllErrors makes Wait return the errors of all failed
+// actions joined together instead of only the first one.
+func WithAllErrors() Option {
+	return func(g *Group) {
+		g.allErrors = true
+	}
+}
+
+// PanicError is returned for an action that panicked,
+// Stack holds the goroutine stack at the moment of the panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// ActionError annotates an error with the name of the action that returned it.
+type ActionError struct {
+	Name string
+	Err  error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("action %q: %v", e.Name, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
 type Group struct {
-	// need to implement
+	wg        sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	sem       chan struct{}
+	allErrors bool
+
+	parent    *Group
+	cancelled atomic.Bool
+
+	mutex sync.Mutex
+	errs  []error
+}
+
+// NewErrGroup returns a group and a context that is cancelled when an action
+// fails or Wait returns, context.Cause reports the first action error.
+func NewErrGroup(ctx context.Context, options ...Option) (*Group, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	g := &Group{ctx: ctx, cancel: cancel}
+	for _, option := range options {
+		option(g)
+	}
+
+	return g, ctx
+}
+
+// Child returns a group nested in g: its context is derived from the
+// context of g, its actions are awaited by Wait of g and its errors
+// fail g as well, unless the child was cancelled with Cancel.
+func (g *Group) Child(options ...Option) (*Group, context.Context) {
+	child, ctx := NewErrGroup(g.ctx, options...)
+	child.parent = g
+	return child, ctx
 }
 
-func NewErrGroup(ctx context.Context) (*Group, context.Context) {
-	// need to implement
-	return &Group{}, ctx
+// Cancel cancels the context of the group and its descendants
+// without affecting the parent and sibling groups.
+func (g *Group) Cancel() {
+	g.cancelled.Store(true)
+	g.cancel(context.Canceled)
 }
 
+// SetLimit limits the number of active actions to n, a negative
+// value removes the limit. It must not be called while actions are active.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("errgroup: modify limit while %v actions are still active", len(g.sem)))
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go blocks until the action can be started without exceeding the limit.
 func (g *Group) Go(action func() error) {
-	// need to implement
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start("", action)
 }
 
+// GoNamed is like Go, but errors of the action are wrapped
+// in an ActionError carrying its name.
+func (g *Group) GoNamed(name string, action func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(name, action)
+}
+
+// TryGo starts the action only if it does not exceed the limit
+// and reports whether it was started.
+func (g *Group) TryGo(action func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+
+	g.start("", action)
+	return true
+}
+
+func (g *Group) start(name string, action func() error) {
+	for group := g; group != nil; group = group.parent {
+		group.wg.Add(1)
+	}
+
+	go func() {
+		defer g.done()
+
+		err := runSafely(action)
+		if err == nil {
+			return
+		}
+		if name != "" {
+			err = &ActionError{Name: name, Err: err}
+		}
+		g.fail(err)
+	}()
+}
+
+func runSafely(action func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return action()
+}
+
+func (g *Group) fail(err error) {
+	g.mutex.Lock()
+	if len(g.errs) == 0 {
+		g.cancel(err)
+		g.errs = append(g.errs, err)
+	} else if g.allErrors {
+		g.errs = append(g.errs, err)
+	}
+	g.mutex.Unlock()
+
+	if g.parent != nil && !g.cancelled.Load() {
+		g.parent.fail(err)
+	}
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	for group := g; group != nil; group = group.parent {
+		group.wg.Done()
+	}
+}
+
+// Wait waits for the actions of the group and all its descendants.
 func (g *Group) Wait() error {
-	// need to implement
-	return nil
+	g.wg.Wait()
+	g.cancel(nil)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.errs) == 0 {
+		return nil
+	}
+	if g.allErrors {
+		return errors.Join(g.errs...)
+	}
+	return g.errs[0]
 }
 
 func TestErrGroupWithoutError(t *testing.T) {
@@ -72,3 +254,213 @@ func TestErrGroupWithError(t *testing.T) {
 	assert.Equal(t, int32(0), counter.Load())
 	assert.Error(t, err)
 }
+
+func TestErrGroupSetLimit(t *testing.T) {
+	var active, maxActive atomic.Int32
+	group, _ := NewErrGroup(context.Background())
+	group.SetLimit(2)
+
+	for i := 0; i < 6; i++ {
+		group.Go(func() error {
+			current := active.Add(1)
+			for {
+				observed := maxActive.Load()
+				if current <= observed || maxActive.CompareAndSwap(observed, current) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			active.Add(-1)
+			return nil
+		})
+	}
+
+	assert.NoError(t, group.Wait())
+	assert.Equal(t, int32(2), maxActive.Load())
+}
+
+func TestErrGroupTryGo(t *testing.T) {
+	release := make(chan struct{})
+	group, ctx := NewErrGroup(context.Background())
+	group.SetLimit(1)
+
+	assert.True(t, group.TryGo(func() error {
+		<-release
+		return errors.New("error")
+	}))
+	assert.False(t, group.TryGo(func() error { return nil }))
+
+	close(release)
+	assert.Error(t, group.Wait())
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	assert.True(t, group.TryGo(func() error { return nil }))
+	assert.Error(t, group.Wait())
+}
+
+func TestErrGroupSetLimitWhileActive(t *testing.T) {
+	release := make(chan struct{})
+	group, _ := NewErrGroup(context.Background())
+	group.SetLimit(1)
+	group.Go(func() error {
+		<-release
+		return nil
+	})
+
+	assert.Panics(t, func() { group.SetLimit(2) })
+
+	close(release)
+	assert.NoError(t, group.Wait())
+}
+
+type codeError struct {
+	code int
+}
+
+func (e *codeError) Error() string {
+	return fmt.Sprintf("code %d", e.code)
+}
+
+func TestErrGroupWithAllErrors(t *testing.T) {
+	errFirst := errors.New("first")
+	group, ctx := NewErrGroup(context.Background(), WithAllErrors())
+
+	group.Go(func() error {
+		return errFirst
+	})
+	group.Go(func() error {
+		<-ctx.Done()
+		return &codeError{code: 42}
+	})
+	group.Go(func() error {
+		return nil
+	})
+
+	err := group.Wait()
+	assert.ErrorIs(t, err, errFirst)
+
+	var target *codeError
+	assert.ErrorAs(t, err, &target)
+	assert.Equal(t, 42, target.code)
+}
+
+func TestErrGroupPanic(t *testing.T) {
+	errCause := errors.New("cause")
+	group, ctx := NewErrGroup(context.Background(), WithAllErrors())
+
+	group.Go(func() error {
+		panic("boom")
+	})
+	group.Go(func() error {
+		panic(errCause)
+	})
+	group.Go(func() error {
+		<-ctx.Done()
+		return nil
+	})
+
+	err := group.Wait()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.ErrorIs(t, err, errCause)
+
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &panicErr)
+	assert.Contains(t, string(panicErr.Stack), "TestErrGroupPanic")
+}
+
+func TestErrGroupCancelCause(t *testing.T) {
+	errFailed := errors.New("failed")
+	group, ctx := NewErrGroup(context.Background())
+
+	var cause atomic.Value
+	group.GoNamed("watcher", func() error {
+		<-ctx.Done()
+		cause.Store(context.Cause(ctx))
+		return ctx.Err()
+	})
+	group.GoNamed("loader", func() error {
+		return errFailed
+	})
+
+	err := group.Wait()
+	assert.ErrorIs(t, err, errFailed)
+	assert.EqualError(t, err, `action "loader": failed`)
+	assert.Equal(t, err, cause.Load())
+
+	var actionErr *ActionError
+	assert.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, "loader", actionErr.Name)
+}
+
+func TestErrGroupCauseWithoutError(t *testing.T) {
+	group, ctx := NewErrGroup(context.Background())
+	group.Go(func() error { return nil })
+
+	assert.NoError(t, group.Wait())
+	assert.ErrorIs(t, context.Cause(ctx), context.Canceled)
+}
+
+func TestErrGroupChildWait(t *testing.T) {
+	var counter atomic.Int32
+	group, _ := NewErrGroup(context.Background())
+	child, _ := group.Child()
+	grandchild, _ := child.Child()
+
+	for _, g := range []*Group{group, child, grandchild} {
+		g.Go(func() error {
+			time.Sleep(50 * time.Millisecond)
+			counter.Add(1)
+			return nil
+		})
+	}
+
+	assert.NoError(t, group.Wait())
+	assert.Equal(t, int32(3), counter.Load())
+}
+
+func TestErrGroupChildError(t *testing.T) {
+	errFailed := errors.New("failed")
+	group, ctx := NewErrGroup(context.Background())
+	child, childCtx := group.Child()
+	sibling, siblingCtx := group.Child()
+
+	child.Go(func() error {
+		return errFailed
+	})
+	sibling.Go(func() error {
+		<-siblingCtx.Done()
+		return nil
+	})
+
+	assert.ErrorIs(t, group.Wait(), errFailed)
+	assert.ErrorIs(t, child.Wait(), errFailed)
+	assert.ErrorIs(t, context.Cause(ctx), errFailed)
+	assert.ErrorIs(t, context.Cause(childCtx), errFailed)
+}
+
+func TestErrGroupCancelSubtree(t *testing.T) {
+	group, _ := NewErrGroup(context.Background())
+	child, childCtx := group.Child()
+	grandchild, grandchildCtx := child.Child()
+	sibling, siblingCtx := group.Child()
+
+	grandchild.Go(func() error {
+		<-grandchildCtx.Done()
+		return grandchildCtx.Err()
+	})
+
+	var siblingFinished atomic.Bool
+	sibling.Go(func() error {
+		time.Sleep(50 * time.Millisecond)
+		siblingFinished.Store(siblingCtx.Err() == nil)
+		return nil
+	})
+
+	child.Cancel()
+
+	assert.NoError(t, group.Wait())
+	assert.ErrorIs(t, child.Wait(), context.Canceled)
+	assert.ErrorIs(t, childCtx.Err(), context.Canceled)
+	assert.True(t, siblingFinished.Load())
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"golang_course/internal/leakcheck"
+)
+
+type ResultGroupOption func(*resultGroupConfig)
+
+type resultGroupConfig struct {
+	bestEffort bool
+}
+
+// WithBestEffort keeps the remaining actions running when one of them
+// fails, Wait then returns the errors of all failed actions joined.
+func WithBestEffort() ResultGroupOption {
+	return func(config *resultGroupConfig) {
+		config.bestEffort = true
+	}
+}
+
+type Result[T any] struct {
+	Index int
+	Value T
+	Err   error
+}
+
+// ResultGroup is a Group whose actions return values. By default it fails
+// fast: the first error cancels the context passed to the other actions.
+type ResultGroup[T any] struct {
+	group *Group
+	ctx   context.Context
+
+	mutex     sync.Mutex
+	results   []T
+	completed []Result[T]
+	notify    chan struct{}
+}
+
+func NewResultGroup[T any](ctx context.Context, options ...ResultGroupOption) (*ResultGroup[T], context.Context) {
+	var config resultGroupConfig
+	for _, option := range options {
+		option(&config)
+	}
+
+	var group *Group
+	actionCtx := ctx
+	if config.bestEffort {
+		group, _ = NewErrGroup(ctx, WithAllErrors())
+	} else {
+		group, actionCtx = NewErrGroup(ctx)
+	}
+
+	return &ResultGroup[T]{
+		group:  group,
+		ctx:    actionCtx,
+		notify: make(chan struct{}, 1),
+	}, actionCtx
+}
+
+func (g *ResultGroup[T]) Go(action func(ctx context.Context) (T, error)) {
+	g.mutex.Lock()
+	index := len(g.results)
+	var zero T
+	g.results = append(g.results, zero)
+	g.mutex.Unlock()
+
+	g.group.Go(func() error {
+		var value T
+		err := runSafely(func() (err error) {
+			value, err = action(g.ctx)
+			return err
+		})
+
+		g.complete(Result[T]{Index: index, Value: value, Err: err})
+		return err
+	})
+}
+
+func (g *ResultGroup[T]) complete(result Result[T]) {
+	g.mutex.Lock()
+	if result.Err == nil {
+		g.results[result.Index] = result.Value
+	}
+	g.completed = append(g.completed, result)
+	g.mutex.Unlock()
+
+	select {
+	case g.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Wait returns the values in the order the actions were submitted,
+// the value of a failed action is left zero.
+func (g *ResultGroup[T]) Wait() ([]T, error) {
+	err := g.group.Wait()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.results, err
+}
+
+// Stream yields results in completion order and is closed once all
+// actions have finished or ctx is done, so a consumer that stops reading
+// early must cancel ctx. It is used instead of Wait and must be called
+// after all actions were submitted.
+func (g *ResultGroup[T]) Stream(ctx context.Context) <-chan Result[T] {
+	out := make(chan Result[T])
+	finished := make(chan struct{})
+	go func() {
+		_ = g.group.Wait()
+		close(finished)
+	}()
+
+	go func() {
+		defer close(out)
+
+		sent := 0
+		for {
+			g.mutex.Lock()
+			pending := g.completed[sent:]
+			g.mutex.Unlock()
+
+			for _, result := range pending {
+				select {
+				case out <- result:
+					sent++
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-g.notify:
+			case <-finished:
+				g.mutex.Lock()
+				done := sent == len(g.completed)
+				g.mutex.Unlock()
+				if done {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func TestResultGroupOrder(t *testing.T) {
+	group, _ := NewResultGroup[int](context.Background())
+	for i := 0; i < 5; i++ {
+		group.Go(func(ctx context.Context) (int, error) {
+			time.Sleep(time.Duration(5-i) * 10 * time.Millisecond)
+			return i * i, nil
+		})
+	}
+
+	results, err := group.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 4, 9, 16}, results)
+}
+
+func TestResultGroupFailFast(t *testing.T) {
+	errFailed := errors.New("failed")
+	group, _ := NewResultGroup[string](context.Background())
+
+	group.Go(func(ctx context.Context) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second):
+			return "slow", nil
+		}
+	})
+	group.Go(func(ctx context.Context) (string, error) {
+		return "", errFailed
+	})
+
+	results, err := group.Wait()
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, []string{"", ""}, results)
+}
+
+func TestResultGroupBestEffort(t *testing.T) {
+	errFailed := errors.New("failed")
+	group, _ := NewResultGroup[string](context.Background(), WithBestEffort())
+
+	group.Go(func(ctx context.Context) (string, error) {
+		return "", errFailed
+	})
+	group.Go(func(ctx context.Context) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			return "slow", nil
+		}
+	})
+
+	results, err := group.Wait()
+	assert.ErrorIs(t, err, errFailed)
+	assert.NotErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"", "slow"}, results)
+}
+
+func TestResultGroupStream(t *testing.T) {
+	errFailed := errors.New("failed")
+	group, _ := NewResultGroup[int](context.Background(), WithBestEffort())
+
+	delays := []int{30, 10, 20}
+	for i, delay := range delays {
+		group.Go(func(ctx context.Context) (int, error) {
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+			if i == 2 {
+				return 0, errFailed
+			}
+			return delay, nil
+		})
+	}
+
+	var results []Result[int]
+	for result := range group.Stream(context.Background()) {
+		results = append(results, result)
+	}
+
+	assert.Equal(t, []Result[int]{
+		{Index: 1, Value: 10},
+		{Index: 2, Err: errFailed},
+		{Index: 0, Value: 30},
+	}, results)
+}
+
+func TestResultGroupStreamAbandoned(t *testing.T) {
+	leakcheck.Check(t)
+
+	group, _ := NewResultGroup[int](context.Background())
+	for i := 0; i < 3; i++ {
+		group.Go(func(ctx context.Context) (int, error) {
+			return i, nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	<-group.Stream(ctx)
+	cancel()
+}
+
+func TestResultGroupPanic(t *testing.T) {
+	group, _ := NewResultGroup[int](context.Background())
+	group.Go(func(ctx context.Context) (int, error) {
+		panic("boom")
+	})
+
+	result := <-group.Stream(context.Background())
+	var panicErr *PanicError
+	assert.ErrorAs(t, result.Err, &panicErr)
+	assert.Equal(t, "boom", panicErr.Value)
+}
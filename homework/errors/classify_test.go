@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflict")
+	ErrInvalidArgument   = errors.New("invalid argument")
+	ErrUnauthenticated   = errors.New("unauthenticated")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrResourceExhausted = errors.New("resource exhausted")
+	ErrDeadlineExceeded  = errors.New("deadline exceeded")
+	ErrUnavailable       = errors.New("unavailable")
+	ErrInternal          = errors.New("internal")
+)
+
+// categories lists the sentinel categories in the order Category checks them.
+var categories = []error{
+	ErrNotFound,
+	ErrConflict,
+	ErrInvalidArgument,
+	ErrUnauthenticated,
+	ErrPermissionDenied,
+	ErrResourceExhausted,
+	ErrDeadlineExceeded,
+	ErrUnavailable,
+	ErrInternal,
+}
+
+var retryableCategories = []error{
+	ErrResourceExhausted,
+	ErrDeadlineExceeded,
+	ErrUnavailable,
+	context.DeadlineExceeded,
+}
+
+// HTTPStatusCodes maps categories to HTTP statuses, uncategorized errors
+// are reported as 500.
+var HTTPStatusCodes = map[error]int{
+	ErrNotFound:          http.StatusNotFound,
+	ErrConflict:          http.StatusConflict,
+	ErrInvalidArgument:   http.StatusBadRequest,
+	ErrUnauthenticated:   http.StatusUnauthorized,
+	ErrPermissionDenied:  http.StatusForbidden,
+	ErrResourceExhausted: http.StatusTooManyRequests,
+	ErrDeadlineExceeded:  http.StatusGatewayTimeout,
+	ErrUnavailable:       http.StatusServiceUnavailable,
+	ErrInternal:          http.StatusInternalServerError,
+}
+
+// ExitCodes maps categories to sysexits(3) codes, uncategorized errors
+// exit with 1.
+var ExitCodes = map[error]int{
+	ErrNotFound:          66, // EX_NOINPUT
+	ErrInvalidArgument:   64, // EX_USAGE
+	ErrUnauthenticated:   77, // EX_NOPERM
+	ErrPermissionDenied:  77, // EX_NOPERM
+	ErrResourceExhausted: 75, // EX_TEMPFAIL
+	ErrDeadlineExceeded:  75, // EX_TEMPFAIL
+	ErrUnavailable:       69, // EX_UNAVAILABLE
+	ErrInternal:          70, // EX_SOFTWARE
+}
+
+type categorizedError struct {
+	err      error
+	category error
+}
+
+// WithCategory marks err with one of the category sentinels, errors.Is
+// matches both the category and everything in the original chain.
+func WithCategory(err error, category error) error {
+	if err == nil {
+		return nil
+	}
+	return &categorizedError{err: err, category: category}
+}
+
+// Errorf is fmt.Errorf followed by WithCategory.
+func Errorf(category error, format string, args ...any) error {
+	return WithCategory(fmt.Errorf(format, args...), category)
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() []error {
+	return []error{e.err, e.category}
+}
+
+// Retryable lets the category override whatever the wrapped error says.
+func (e *categorizedError) Retryable() bool {
+	return isRetryableCategory(e.category)
+}
+
+// isRetryableCategory and lookup compare errors with == instead of
+// indexing a map with them: errors of slice, map or func types are not
+// hashable and would make the map lookup panic.
+func isRetryableCategory(err error) bool {
+	for _, category := range retryableCategories {
+		if err == category {
+			return true
+		}
+	}
+	return false
+}
+
+func lookup(table map[error]int, category error) (int, bool) {
+	for key, value := range table {
+		if key == category {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// Category returns the category assigned by the outermost WithCategory in
+// err's chain, falling back to the first sentinel matched, or nil.
+func Category(err error) error {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category
+	}
+	for _, category := range categories {
+		if errors.Is(err, category) {
+			return category
+		}
+	}
+	return nil
+}
+
+// IsRetryable reports whether retrying may help. An error is retryable if
+// the first error in its chain that expresses an opinion, through a
+// Retryable() or Temporary() method or a retryable category, says so. A
+// MultiError or errors.Join result is retryable only if all of its errors are.
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case interface{ Retryable() bool }:
+		return e.Retryable()
+	case interface{ Temporary() bool }:
+		return e.Temporary()
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		for _, err := range errs {
+			if !IsRetryable(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	if isRetryableCategory(err) {
+		return true
+	}
+	return IsRetryable(errors.Unwrap(err))
+}
+
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if status, ok := lookup(HTTPStatusCodes, Category(err)); ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if code, ok := lookup(ExitCodes, Category(err)); ok {
+		return code
+	}
+	return 1
+}
+
+type sliceError []string
+
+func (e sliceError) Error() string {
+	return strings.Join(e, ", ")
+}
+
+type temporaryError struct {
+	temporary bool
+}
+
+func (e temporaryError) Error() string {
+	return "temporary error"
+}
+
+func (e temporaryError) Temporary() bool {
+	return e.temporary
+}
+
+func TestWithCategory(t *testing.T) {
+	errOriginal := errors.New("no such user")
+	err := WithCategory(fmt.Errorf("get user: %w", errOriginal), ErrNotFound)
+
+	assert.EqualError(t, err, "get user: no such user")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, errOriginal)
+	assert.Equal(t, ErrNotFound, Category(err))
+	assert.Nil(t, WithCategory(nil, ErrNotFound))
+
+	err = Errorf(ErrConflict, "user %q exists", "alice")
+	assert.EqualError(t, err, `user "alice" exists`)
+	assert.Equal(t, ErrConflict, Category(fmt.Errorf("create: %w", err)))
+	assert.Nil(t, Category(errors.New("plain")))
+}
+
+func TestRecategorize(t *testing.T) {
+	err := WithCategory(ErrNotFound, ErrUnavailable)
+	assert.Equal(t, ErrUnavailable, Category(err))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(err))
+	assert.True(t, IsRetryable(err))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = WithCategory(fmt.Errorf("db: %w", ErrNotFound), ErrInternal)
+	assert.Equal(t, ErrInternal, Category(err))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(err))
+	assert.Equal(t, 70, ExitCode(err))
+
+	err = fmt.Errorf("handler: %w", WithCategory(Errorf(ErrConflict, "exists"), ErrInvalidArgument))
+	assert.Equal(t, ErrInvalidArgument, Category(err))
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+}
+
+func TestIsRetryable(t *testing.T) {
+	unavailable := Errorf(ErrUnavailable, "backend down")
+	invalid := Errorf(ErrInvalidArgument, "bad id")
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("plain"), false},
+		{"sentinel", ErrUnavailable, true},
+		{"category", unavailable, true},
+		{"wrapped category", fmt.Errorf("call: %w", unavailable), true},
+		{"permanent category", invalid, false},
+		{"category overrides cause", WithCategory(context.DeadlineExceeded, ErrInvalidArgument), false},
+		{"context deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), true},
+		{"temporary", temporaryError{temporary: true}, true},
+		{"not temporary", fmt.Errorf("call: %w", temporaryError{}), false},
+		{"all retryable", Append(unavailable, ErrResourceExhausted), true},
+		{"one permanent", Append(unavailable, invalid), false},
+		{"nested", fmt.Errorf("batch: %w", Append(unavailable, errors.Join(ErrDeadlineExceeded, temporaryError{temporary: true}))), true},
+		{"nested permanent", Append(unavailable, errors.Join(ErrDeadlineExceeded, errors.New("plain"))), false},
+		{"traced", Wrap(unavailable, "E503"), true},
+		{"unhashable", sliceError{"a"}, false},
+		{"joined unhashable", errors.Join(unavailable, sliceError{"a"}), false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.retryable, IsRetryable(test.err), test.name)
+	}
+}
+
+func TestStatusMapping(t *testing.T) {
+	assert.Equal(t, http.StatusOK, HTTPStatus(nil))
+	assert.Equal(t, http.StatusNotFound, HTTPStatus(Errorf(ErrNotFound, "no user")))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(fmt.Errorf("call: %w", ErrUnavailable)))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(errors.New("plain")))
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(Append(errors.New("plain"), Errorf(ErrInvalidArgument, "bad"))))
+
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, 64, ExitCode(Errorf(ErrInvalidArgument, "bad flag")))
+	assert.Equal(t, 1, ExitCode(Errorf(ErrConflict, "exists")))
+	assert.Equal(t, 1, ExitCode(errors.New("plain")))
+
+	unhashable := WithCategory(errors.New("invalid fields"), sliceError{"name"})
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(unhashable))
+	assert.Equal(t, 1, ExitCode(unhashable))
+	assert.False(t, IsRetryable(unhashable))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Collector gathers errors from many goroutines. With a positive limit it
+// keeps only the first limit errors and counts the rest as overflow.
+type Collector struct {
+	mutex    sync.Mutex
+	multi    MultiError
+	limit    int
+	overflow int
+}
+
+func NewCollector(limit int) *Collector {
+	return &Collector{limit: limit}
+}
+
+// Add is safe for concurrent use, nil errors are ignored
+// and MultiErrors are flattened.
+func (c *Collector) Add(err error) {
+	if err == nil {
+		return
+	}
+
+	var added MultiError
+	added.append(err)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, err := range added.errors {
+		if c.limit > 0 && len(c.multi.errors) >= c.limit {
+			c.overflow++
+			continue
+		}
+		c.multi.errors = append(c.multi.errors, err)
+	}
+}
+
+// Wrap adapts an error-returning task to a func() that reports its
+// error to the collector, e.g. for WorkerPool.AddTask.
+func (c *Collector) Wrap(task func() error) func() {
+	return func() {
+		c.Add(task())
+	}
+}
+
+// Overflow returns the number of errors dropped because of the limit.
+func (c *Collector) Overflow() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.overflow
+}
+
+// Err returns a copy of the collected errors, or nil if there are none.
+// Compare the result with nil before storing it in an error variable.
+func (c *Collector) Err() *MultiError {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.multi.errors) == 0 {
+		return nil
+	}
+	return &MultiError{errors: append([]error(nil), c.multi.errors...)}
+}
+
+func TestCollector(t *testing.T) {
+	errSentinel := errors.New("sentinel")
+	collector := NewCollector(0)
+	assert.Nil(t, collector.Err())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				collector.Add(fmt.Errorf("task %d: %w", i, errSentinel))
+			} else {
+				collector.Add(nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := collector.Err()
+	assert.Len(t, err.Unwrap(), 50)
+	assert.ErrorIs(t, err, errSentinel)
+	assert.Equal(t, 0, collector.Overflow())
+}
+
+func TestCollectorLimit(t *testing.T) {
+	collector := NewCollector(3)
+
+	collector.Add(errors.New("error 1"))
+	collector.Add(Append(errors.New("error 2"), errors.New("error 3"), errors.New("error 4")))
+	collector.Add(errors.New("error 5"))
+
+	err := collector.Err()
+	assert.Equal(t, "3 errors occured:\n\t* error 1\t* error 2\t* error 3\n", err.Error())
+	assert.Equal(t, 2, collector.Overflow())
+
+	// the returned error is not affected by later additions
+	collector.Add(errors.New("error 6"))
+	assert.Len(t, err.Unwrap(), 3)
+}
+
+func TestCollectorWrap(t *testing.T) {
+	errFailed := errors.New("failed")
+	collector := NewCollector(0)
+
+	var wg sync.WaitGroup
+	tasks := []func() error{
+		func() error { return nil },
+		func() error { return errFailed },
+	}
+	for _, task := range tasks {
+		wg.Add(1)
+		run := collector.Wrap(task)
+		go func() {
+			defer wg.Done()
+			run()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []error{errFailed}, collector.Err().Unwrap())
+}
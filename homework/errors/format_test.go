@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Formatter renders the message of a MultiError from its errors.
+type Formatter func(errs []error) string
+
+func header(count int) string {
+	if count == 1 {
+		return "1 error occured:\n"
+	}
+	return fmt.Sprintf("%d errors occured:\n", count)
+}
+
+// ListFormat is the original MultiError layout, the bullets follow each
+// other on the same line.
+func ListFormat(errs []error) string {
+	var builder strings.Builder
+	builder.WriteString(header(len(errs)))
+	for _, err := range errs {
+		builder.WriteString("\t* ")
+		builder.WriteString(err.Error())
+	}
+	builder.WriteString("\n")
+
+	return builder.String()
+}
+
+// BulletListFormat puts every error on its own line.
+func BulletListFormat(errs []error) string {
+	var builder strings.Builder
+	builder.WriteString(header(len(errs)))
+	for _, err := range errs {
+		builder.WriteString("\t* ")
+		builder.WriteString(err.Error())
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+func SingleLineFormat(errs []error) string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// JSONFormat renders {"errors": ["message", ...]}.
+func JSONFormat(errs []error) string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	data, _ := json.Marshal(struct {
+		Errors []string `json:"errors"`
+	}{messages})
+	return string(data)
+}
+
+// rendered returns the errors to be printed, without
+// the repeated messages if deduplication is on.
+func (e *MultiError) rendered() []error {
+	if !e.Deduplicate {
+		return e.errors
+	}
+
+	seen := make(map[string]struct{}, len(e.errors))
+	unique := make([]error, 0, len(e.errors))
+	for _, err := range e.errors {
+		message := err.Error()
+		if _, ok := seen[message]; ok {
+			continue
+		}
+		seen[message] = struct{}{}
+		unique = append(unique, err)
+	}
+
+	return unique
+}
+
+// Format prints every error with %+v for the "%+v" verb, so errors that
+// support it show their stack traces, and the message otherwise.
+func (e *MultiError) Format(state fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && state.Flag('+'):
+		errs := e.rendered()
+		_, _ = io.WriteString(state, header(len(errs)))
+		for _, err := range errs {
+			_, _ = fmt.Fprintf(state, "\t* %+v\n", err)
+		}
+	case verb == 'q':
+		_, _ = fmt.Fprintf(state, "%q", e.Error())
+	default:
+		_, _ = io.WriteString(state, e.Error())
+	}
+}
+
+type detailedError struct {
+	message string
+}
+
+func (e *detailedError) Error() string {
+	return e.message
+}
+
+func (e *detailedError) Format(state fmt.State, verb rune) {
+	if verb == 'v' && state.Flag('+') {
+		_, _ = io.WriteString(state, e.message+" [details]")
+		return
+	}
+	_, _ = io.WriteString(state, e.message)
+}
+
+func newMultiError(errs ...error) *MultiError {
+	var multi *MultiError
+	errors.As(Append(nil, errs...), &multi)
+	return multi
+}
+
+func TestMultiErrorFormatters(t *testing.T) {
+	multi := newMultiError(errors.New("error 1"), errors.New("error 2"))
+
+	assert.Equal(t, "2 errors occured:\n\t* error 1\t* error 2\n", multi.Error())
+
+	multi.Formatter = BulletListFormat
+	assert.Equal(t, "2 errors occured:\n\t* error 1\n\t* error 2\n", multi.Error())
+
+	multi.Formatter = SingleLineFormat
+	assert.Equal(t, "error 1; error 2", multi.Error())
+
+	multi.Formatter = JSONFormat
+	assert.JSONEq(t, `{"errors": ["error 1", "error 2"]}`, multi.Error())
+}
+
+func TestMultiErrorSingular(t *testing.T) {
+	multi := newMultiError(errors.New("error 1"))
+
+	assert.Equal(t, "1 error occured:\n\t* error 1\n", multi.Error())
+
+	multi.Formatter = BulletListFormat
+	assert.Equal(t, "1 error occured:\n\t* error 1\n", multi.Error())
+}
+
+func TestMultiErrorDeduplicate(t *testing.T) {
+	errTimeout := errors.New("timeout")
+	multi := newMultiError(errTimeout, errors.New("timeout"), errors.New("refused"), errTimeout)
+	multi.Formatter = SingleLineFormat
+
+	assert.Equal(t, "timeout; timeout; refused; timeout", multi.Error())
+
+	multi.Deduplicate = true
+	assert.Equal(t, "timeout; refused", multi.Error())
+	assert.Len(t, multi.Unwrap(), 4)
+}
+
+func TestMultiErrorFormat(t *testing.T) {
+	multi := newMultiError(errors.New("error 1"), &detailedError{message: "error 2"})
+	multi.Formatter = SingleLineFormat
+
+	assert.Equal(t, "error 1; error 2", fmt.Sprintf("%v", multi))
+	assert.Equal(t, "error 1; error 2", fmt.Sprintf("%s", multi))
+	assert.Equal(t, `"error 1; error 2"`, fmt.Sprintf("%q", multi))
+	assert.Equal(t, "2 errors occured:\n\t* error 1\n\t* error 2 [details]\n", fmt.Sprintf("%+v", multi))
+}
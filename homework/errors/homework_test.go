@@ -2,25 +2,68 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-// go test -v homework_test.go
+// go test -v .
 
+// MultiError is a list of errors visible to errors.Is and errors.As
+// through Unwrap, just like the result of errors.Join.
 type MultiError struct {
-	// need to implement
+	errors []error
+
+	// Formatter renders Error(), ListFormat is used if it is nil.
+	Formatter Formatter
+	// Deduplicate drops errors whose messages were already rendered.
+	Deduplicate bool
 }
 
 func (e *MultiError) Error() string {
-	// need to implement
-	return ""
+	format := e.Formatter
+	if format == nil {
+		format = ListFormat
+	}
+	return format(e.rendered())
+}
+
+func (e *MultiError) Unwrap() []error {
+	return e.errors
 }
 
-func Append(err error, errs ...error) *MultiError {
-	// need to implement
-	return nil
+// Append adds errs to err, flattening nested MultiErrors and skipping
+// nils, a MultiError passed as err is extended in place. It returns an
+// untyped nil if there is no error at all, so the result can be compared
+// with nil after being stored in an error.
+func Append(err error, errs ...error) error {
+	multi, ok := err.(*MultiError)
+	if !ok || multi == nil {
+		multi = &MultiError{}
+		multi.append(err)
+	}
+	for _, err := range errs {
+		multi.append(err)
+	}
+
+	if len(multi.errors) == 0 {
+		return nil
+	}
+	return multi
+}
+
+func (e *MultiError) append(err error) {
+	if multi, ok := err.(*MultiError); ok {
+		if multi != nil {
+			e.errors = append(e.errors, multi.errors...)
+		}
+		return
+	}
+
+	if err != nil {
+		e.errors = append(e.errors, err)
+	}
 }
 
 func TestMultiError(t *testing.T) {
@@ -31,3 +74,58 @@ func TestMultiError(t *testing.T) {
 	expectedMessage := "2 errors occured:\n\t* error 1\t* error 2\n"
 	assert.EqualError(t, err, expectedMessage)
 }
+
+type pathError struct {
+	path string
+}
+
+func (e *pathError) Error() string {
+	return "bad path " + e.path
+}
+
+func TestMultiErrorNil(t *testing.T) {
+	var err error
+	err = Append(err)
+	assert.Nil(t, err)
+
+	err = Append(err, nil, nil)
+	assert.Nil(t, err)
+
+	var typedNil *MultiError
+	err = Append(typedNil, nil)
+	assert.Nil(t, err)
+}
+
+func TestMultiErrorFlatten(t *testing.T) {
+	err1, err2, err3 := errors.New("error 1"), errors.New("error 2"), errors.New("error 3")
+
+	inner := Append(err1, nil, err2)
+	err := Append(nil, inner, Append(err3))
+
+	var multi *MultiError
+	assert.ErrorAs(t, err, &multi)
+	assert.Equal(t, []error{err1, err2, err3}, multi.Unwrap())
+}
+
+func TestMultiErrorIsAs(t *testing.T) {
+	errNotFound := errors.New("not found")
+	err := Append(errNotFound, fmt.Errorf("open: %w", &pathError{path: "/tmp"}))
+
+	assert.ErrorIs(t, err, errNotFound)
+
+	var target *pathError
+	assert.ErrorAs(t, err, &target)
+	assert.Equal(t, "/tmp", target.path)
+}
+
+func TestMultiErrorJoin(t *testing.T) {
+	err1, err2, err3 := errors.New("error 1"), errors.New("error 2"), errors.New("error 3")
+
+	joined := errors.Join(Append(err1, err2), err3)
+	assert.ErrorIs(t, joined, err1)
+	assert.ErrorIs(t, joined, err3)
+
+	err := Append(errors.Join(err1, err2), err3)
+	assert.ErrorIs(t, err, err2)
+	assert.ErrorIs(t, err, err3)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const maxStackDepth = 32
+
+// TracedError wraps an error with the call stack of its creation,
+// an error code and typed fields. It is found by errors.As through
+// MultiErrors and fmt.Errorf wrapping.
+type TracedError struct {
+	err    error
+	Code   string
+	Fields []slog.Attr
+	stack  []uintptr
+}
+
+// Wrap returns nil for a nil error.
+func Wrap(err error, code string, fields ...slog.Attr) error {
+	if err == nil {
+		return nil
+	}
+	return &TracedError{err: err, Code: code, Fields: fields, stack: callers()}
+}
+
+func New(message string, code string, fields ...slog.Attr) error {
+	return &TracedError{err: errors.New(message), Code: code, Fields: fields, stack: callers()}
+}
+
+func callers() []uintptr {
+	pcs := make([]uintptr, maxStackDepth)
+	// skip runtime.Callers, callers and the constructor
+	n := runtime.Callers(3, pcs)
+	return pcs[:n]
+}
+
+func (e *TracedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *TracedError) Unwrap() error {
+	return e.err
+}
+
+func (e *TracedError) StackTrace() []runtime.Frame {
+	var trace []runtime.Frame
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		frame, more := frames.Next()
+		trace = append(trace, frame)
+		if !more {
+			return trace
+		}
+	}
+}
+
+// Format prints the code, the fields and the stack trace for "%+v".
+func (e *TracedError) Format(state fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && state.Flag('+'):
+		_, _ = fmt.Fprintf(state, "%+v", e.err)
+		if e.Code != "" {
+			_, _ = fmt.Fprintf(state, " [%s]", e.Code)
+		}
+		for _, field := range e.Fields {
+			_, _ = fmt.Fprintf(state, " %s=%v", field.Key, field.Value)
+		}
+		for _, frame := range e.StackTrace() {
+			_, _ = fmt.Fprintf(state, "\n\t\t%s\n\t\t\t%s:%d", frame.Function, frame.File, frame.Line)
+		}
+	case verb == 'q':
+		_, _ = fmt.Fprintf(state, "%q", e.Error())
+	default:
+		_, _ = io.WriteString(state, e.Error())
+	}
+}
+
+// LogValue renders the error as a group of slog attributes
+// with the place it was created at as "source".
+func (e *TracedError) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("message", e.Error())}
+	if e.Code != "" {
+		attrs = append(attrs, slog.String("code", e.Code))
+	}
+	attrs = append(attrs, e.Fields...)
+	if trace := e.StackTrace(); len(trace) > 0 {
+		attrs = append(attrs, slog.String("source", trace[0].File+":"+strconv.Itoa(trace[0].Line)))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// LogValue renders every error under its index, traced errors
+// are expanded into their attributes.
+func (e *MultiError) LogValue() slog.Value {
+	errs := e.rendered()
+	attrs := make([]slog.Attr, 0, len(errs)+1)
+	attrs = append(attrs, slog.Int("count", len(errs)))
+	for i, err := range errs {
+		var traced *TracedError
+		if errors.As(err, &traced) && traced != err {
+			// keep the message of the wrapping error
+			attrs = append(attrs, slog.Group(strconv.Itoa(i), slog.String("message", err.Error()), slog.Any("cause", traced)))
+			continue
+		}
+		if _, ok := err.(slog.LogValuer); ok {
+			attrs = append(attrs, slog.Any(strconv.Itoa(i), err))
+			continue
+		}
+		attrs = append(attrs, slog.String(strconv.Itoa(i), err.Error()))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+func TestTracedError(t *testing.T) {
+	errNotFound := errors.New("not found")
+	err := Wrap(errNotFound, "E404", slog.String("user", "alice"), slog.Int("attempt", 3))
+
+	assert.ErrorIs(t, err, errNotFound)
+	assert.EqualError(t, err, "not found")
+	assert.Nil(t, Wrap(nil, "E404"))
+
+	var traced *TracedError
+	assert.ErrorAs(t, fmt.Errorf("lookup: %w", Append(errors.New("other"), err)), &traced)
+	assert.Equal(t, "E404", traced.Code)
+	assert.Equal(t, []slog.Attr{slog.String("user", "alice"), slog.Int("attempt", 3)}, traced.Fields)
+	assert.Contains(t, traced.StackTrace()[0].Function, "TestTracedError")
+}
+
+func TestTracedErrorFormat(t *testing.T) {
+	err := New("disk full", "E507", slog.String("path", "/var"))
+
+	assert.Equal(t, "disk full", fmt.Sprintf("%v", err))
+	assert.Equal(t, `"disk full"`, fmt.Sprintf("%q", err))
+
+	detailed := fmt.Sprintf("%+v", err)
+	assert.True(t, strings.HasPrefix(detailed, "disk full [E507] path=/var\n\t\t"))
+	assert.Contains(t, detailed, "TestTracedErrorFormat")
+	assert.Contains(t, detailed, "traced_test.go:")
+
+	multi := fmt.Sprintf("%+v", Append(errors.New("error 1"), err))
+	assert.True(t, strings.HasPrefix(multi, "2 errors occured:\n\t* error 1\n\t* disk full [E507] path=/var\n"))
+	assert.Contains(t, multi, "TestTracedErrorFormat")
+}
+
+func TestTracedErrorSlog(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, nil))
+
+	traced := New("timeout", "E504", slog.Int("retries", 2))
+	err := Append(errors.New("plain"), traced, fmt.Errorf("call: %w", traced))
+	logger.Error("request failed", "error", err)
+
+	var record struct {
+		Error map[string]any `json:"error"`
+	}
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &record))
+	assert.Equal(t, float64(3), record.Error["count"])
+	assert.Equal(t, "plain", record.Error["0"])
+
+	direct := record.Error["1"].(map[string]any)
+	assert.Equal(t, "timeout", direct["message"])
+	assert.Equal(t, "E504", direct["code"])
+	assert.Equal(t, float64(2), direct["retries"])
+	assert.Contains(t, direct["source"], "traced_test.go:")
+
+	wrapped := record.Error["2"].(map[string]any)
+	assert.Equal(t, "call: timeout", wrapped["message"])
+	assert.Equal(t, "E504", wrapped["cause"].(map[string]any)["code"])
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func WithClock(clock Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+// WithAging raises the effective priority of a waiting task by step for
+// every interval it spends in the scheduler, so low-priority tasks are
+// eventually picked even under a steady flow of high-priority ones.
+// Aging is a feature of the default policy: NewScheduler panics if it is
+// combined with WithPolicy, and WithAging panics if interval is not positive.
+func WithAging(step int, interval time.Duration) Option {
+	if interval <= 0 {
+		panic("scheduler: non-positive aging interval")
+	}
+	return func(s *Scheduler) {
+		s.agingRate = float64(step) / float64(interval)
+	}
+}
+
+// WithPriorityBands groups wait statistics by priority: a band starts
+// at each of the bounds, lower priorities fall into the first band.
+func WithPriorityBands(bounds ...int) Option {
+	return func(s *Scheduler) {
+		s.stats = newWaitStats(bounds)
+	}
+}
+
+// priorityPolicy is the default policy, with aging it grows the priority
+// linearly with the waiting time. Since all tasks age at the same rate,
+// the effective priority at any moment keeps the same order as
+// priority - rate * (enqueuedAt - epoch), which is fixed at insertion
+// and does not require reordering the heap as time goes on.
+type priorityPolicy struct {
+	agingRate float64
+	epoch     time.Time
+}
+
+func (p *priorityPolicy) Enqueue(task QueuedTask) float64 {
+	if p.epoch.IsZero() {
+		p.epoch = task.EnqueuedAt
+	}
+	return p.Reprioritize(task)
+}
+
+func (p *priorityPolicy) Reprioritize(task QueuedTask) float64 {
+	return float64(task.Priority) - p.agingRate*float64(task.EnqueuedAt.Sub(p.epoch))
+}
+
+func (p *priorityPolicy) Dequeue(QueuedTask) {}
+
+func (p *priorityPolicy) Remove(QueuedTask) {}
+
+type BandStats struct {
+	MinPriority int
+	Count       int
+	MaxWait     time.Duration
+}
+
+type SchedulerStats struct {
+	Bands []BandStats
+}
+
+type waitStats struct {
+	mutex sync.Mutex
+	bands []BandStats
+}
+
+func newWaitStats(bounds []int) *waitStats {
+	bounds = append([]int(nil), bounds...)
+	sort.Ints(bounds)
+	if len(bounds) == 0 {
+		bounds = []int{math.MinInt}
+	}
+
+	stats := &waitStats{bands: make([]BandStats, len(bounds))}
+	for i, bound := range bounds {
+		stats.bands[i].MinPriority = bound
+	}
+	return stats
+}
+
+func (s *waitStats) observe(priority int, wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	idx := sort.Search(len(s.bands), func(i int) bool {
+		return s.bands[i].MinPriority > priority
+	})
+	if idx > 0 {
+		idx--
+	}
+
+	band := &s.bands[idx]
+	band.Count++
+	band.MaxWait = max(band.MaxWait, wait)
+}
+
+// Stats returns the maximum time tasks spent in the scheduler
+// per priority band, counting the tasks already taken out.
+func (s *Scheduler) Stats() SchedulerStats {
+	s.stats.mutex.Lock()
+	defer s.stats.mutex.Unlock()
+
+	return SchedulerStats{Bands: append([]BandStats(nil), s.stats.bands...)}
+}
+
+func TestSchedulerAging(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	scheduler := NewScheduler(WithAging(1, time.Second), WithClock(clock))
+
+	scheduler.AddTask(Task{Identifier: 1, Priority: 0})
+	clock.Advance(10 * time.Second)
+	scheduler.AddTask(Task{Identifier: 2, Priority: 5})
+	scheduler.AddTask(Task{Identifier: 3, Priority: 15})
+
+	var order []int
+	for scheduler.Len() > 0 {
+		task, _ := scheduler.GetTask()
+		order = append(order, task.Identifier)
+	}
+	assert.Equal(t, []int{3, 1, 2}, order)
+}
+
+func TestSchedulerAgingPreventsStarvation(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	scheduler := NewScheduler(WithClock(clock), WithAging(1, time.Second))
+
+	scheduler.AddTask(Task{Identifier: 0, Priority: 0})
+	for i := 1; ; i++ {
+		scheduler.AddTask(Task{Identifier: i, Priority: 10})
+		clock.Advance(time.Second)
+
+		task, _ := scheduler.GetTask()
+		if task.Identifier == 0 {
+			assert.LessOrEqual(t, i, 11)
+			return
+		}
+	}
+}
+
+func TestSchedulerAgingOptions(t *testing.T) {
+	assert.Panics(t, func() { WithAging(1, 0) })
+	assert.Panics(t, func() { NewScheduler(WithPolicy(EarliestDeadlineFirst()), WithAging(1, time.Second)) })
+	assert.Panics(t, func() { NewScheduler(WithAging(1, time.Second), WithPolicy(EarliestDeadlineFirst())) })
+	assert.NotPanics(t, func() { NewScheduler(WithAging(0, time.Second), WithPolicy(EarliestDeadlineFirst())) })
+}
+
+func TestSchedulerWaitStats(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	scheduler := NewScheduler(WithClock(clock), WithPriorityBands(10, 0))
+
+	scheduler.AddTask(Task{Identifier: 1, Priority: -5})
+	scheduler.AddTask(Task{Identifier: 2, Priority: 5})
+	scheduler.AddTask(Task{Identifier: 3, Priority: 20})
+
+	clock.Advance(time.Second)
+	scheduler.GetTask()
+	clock.Advance(time.Second)
+	scheduler.GetTask()
+	clock.Advance(time.Second)
+	scheduler.GetTask()
+
+	assert.Equal(t, []BandStats{
+		{MinPriority: 0, Count: 2, MaxWait: 3 * time.Second},
+		{MinPriority: 10, Count: 1, MaxWait: time.Second},
+	}, scheduler.Stats().Bands)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	dayField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	weekdayField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// CronSchedule is a parsed standard 5-field cron expression.
+// Each field is a bit set of the values it matches.
+type CronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// cron matches a day by either of the day fields when both of them
+	// are restricted, that is neither starts with "*" like Vixie cron
+	anyDay bool
+}
+
+// ParseCron parses "minute hour day-of-month month day-of-week", where each
+// field is "*" or a list of values and ranges with optional steps, e.g.
+// "*/15 9-17 * JAN-MAR,DEC MON-FRI". Sunday is both 0 and 7.
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var s CronSchedule
+	var err error
+	targets := []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.minutes, minuteField},
+		{&s.hours, hourField},
+		{&s.days, dayField},
+		{&s.months, monthField},
+		{&s.weekdays, weekdayField},
+	}
+	for i, target := range targets {
+		if *target.bits, err = parseCronField(fields[i], target.field); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+	}
+
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.anyDay = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+
+	return &s, nil
+}
+
+func parseCronField(text string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", field.name, stepText)
+			}
+		}
+
+		low, high := field.min, field.max
+		if rangeText != "*" {
+			lowText, highText, isRange := strings.Cut(rangeText, "-")
+
+			var err error
+			if low, err = field.value(lowText); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = field.value(highText); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = field.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("%s: invalid range %q", field.name, rangeText)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+func (f cronField) value(text string) (int, error) {
+	if value, ok := f.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, text)
+	}
+	return value, nil
+}
+
+func (s *CronSchedule) matchesDay(wall time.Time) bool {
+	day := s.days&(1<<wall.Day()) != 0
+	weekday := s.weekdays&(1<<wall.Weekday()) != 0
+	if s.anyDay {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// Next returns the first matching time after the given one in its
+// location, or zero time if there is none within five years. Wall times
+// skipped by a DST transition never match, wall times repeated by it
+// match only once.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	// calendar arithmetic is done on wall clock values in UTC,
+	// which has no transitions
+	wall := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, time.UTC)
+	limit := wall.AddDate(5, 0, 0)
+
+	for wall.Before(limit) {
+		switch {
+		case s.months&(1<<wall.Month()) == 0:
+			wall = time.Date(wall.Year(), wall.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(wall):
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<wall.Hour()) == 0:
+			wall = wall.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<wall.Minute()) == 0:
+			wall = wall.Add(time.Minute)
+		default:
+			next := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, after.Location())
+			if next.Hour() == wall.Hour() && next.Minute() == wall.Minute() && next.After(after) {
+				return next
+			}
+			wall = wall.Add(time.Minute)
+		}
+	}
+
+	return time.Time{}
+}
+
+// Cron dispatches recurring tasks to an Executor with their priority,
+// using a TimerWheel to wait for the next run.
+type Cron struct {
+	mutex    sync.Mutex
+	executor *Executor
+	wheel    *TimerWheel
+	location *time.Location
+	timers   map[string]TimerID
+}
+
+func NewCron(executor *Executor, wheel *TimerWheel, location *time.Location) *Cron {
+	return &Cron{
+		executor: executor,
+		wheel:    wheel,
+		location: location,
+		timers:   make(map[string]TimerID),
+	}
+}
+
+// Add registers the job under a unique name, replacing a job with
+// the same name. Every run is added to the executor as a new Task with a
+// reserved identifier, so it never replaces tasks added by others.
+func (c *Cron) Add(name string, expr string, priority int, run func()) error {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if id, ok := c.timers[name]; ok {
+		c.wheel.Cancel(id)
+	}
+	c.scheduleLocked(name, schedule, c.wheel.clock.Now().In(c.location), priority, run)
+	return nil
+}
+
+func (c *Cron) Remove(name string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id, ok := c.timers[name]
+	if !ok {
+		return false
+	}
+
+	delete(c.timers, name)
+	return c.wheel.Cancel(id)
+}
+
+func (c *Cron) scheduleLocked(name string, schedule *CronSchedule, after time.Time, priority int, run func()) {
+	next := schedule.Next(after)
+	if next.IsZero() {
+		delete(c.timers, name)
+		return
+	}
+
+	var id TimerID
+	id = c.wheel.Schedule(next.Sub(c.wheel.clock.Now()), func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.timers[name] != id {
+			return
+		}
+
+		_ = c.executor.addInternalTask(Task{Priority: priority, Run: run})
+		// the next run is computed from the planned time, so that a late
+		// tick does not shift the following runs, but runs missed by a
+		// poll that came later than that are skipped
+		after := next
+		if now := c.wheel.clock.Now().In(c.location); now.After(after) {
+			after = now
+		}
+		c.scheduleLocked(name, schedule, after, priority, run)
+	})
+	c.timers[name] = id
+}
+
+func TestParseCron(t *testing.T) {
+	schedule, err := ParseCron("*/15 9-17/4 1,15 JAN-mar,Dec mon-FRI")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1|1<<15|1<<30|1<<45), schedule.minutes)
+	assert.Equal(t, uint64(1<<9|1<<13|1<<17), schedule.hours)
+	assert.Equal(t, uint64(1<<1|1<<15), schedule.days)
+	assert.Equal(t, uint64(1<<1|1<<2|1<<3|1<<12), schedule.months)
+	assert.Equal(t, uint64(0b111110), schedule.weekdays)
+	assert.True(t, schedule.anyDay)
+
+	schedule, err = ParseCron("5/20 * * * 7")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1<<5|1<<25|1<<45), schedule.minutes)
+	assert.Equal(t, uint64(1|1<<7), schedule.weekdays)
+	assert.False(t, schedule.anyDay)
+
+	// every other day, but only on Mondays
+	schedule, err = ParseCron("0 0 */2 * mon")
+	assert.NoError(t, err)
+	assert.False(t, schedule.anyDay)
+	assert.Equal(t, "2024-06-03T00:00:00Z", schedule.Next(time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)).Format(time.RFC3339))
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		expr     string
+		after    string
+		expected string
+	}{
+		{"* * * * *", "2024-01-01T10:00:30Z", "2024-01-01T10:01:00Z"},
+		{"30 9 * * *", "2024-01-01T09:30:00Z", "2024-01-02T09:30:00Z"},
+		{"0 0 29 feb *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 12 * * mon", "2024-01-03T00:00:00Z", "2024-01-08T12:00:00Z"},
+		{"0 0 13 * fri", "2024-01-01T00:00:00Z", "2024-01-05T00:00:00Z"},
+		{"0 0 31 * *", "2024-04-01T00:00:00Z", "2024-05-31T00:00:00Z"},
+		{"0 0 1 1 *", "2024-12-31T23:59:00Z", "2025-01-01T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseCron(test.expr)
+		assert.NoError(t, err)
+
+		after, _ := time.Parse(time.RFC3339, test.after)
+		expected, _ := time.Parse(time.RFC3339, test.expected)
+		assert.Equal(t, expected, schedule.Next(after), test.expr)
+	}
+
+	schedule, _ := ParseCron("0 0 30 2 *")
+	assert.True(t, schedule.Next(time.Now()).IsZero())
+}
+
+func TestCronScheduleNextDST(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	// 2024-03-10 02:00 EST jumps to 03:00 EDT
+	schedule, _ := ParseCron("30 2 * * *")
+	next := schedule.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, location))
+	assert.Equal(t, time.Date(2024, 3, 11, 2, 30, 0, 0, location), next)
+
+	schedule, _ = ParseCron("*/30 * * * *")
+	next = schedule.Next(time.Date(2024, 3, 10, 1, 30, 0, 0, location))
+	assert.Equal(t, time.Date(2024, 3, 10, 3, 0, 0, 0, location), next)
+	assert.Equal(t, 30*time.Minute, next.Sub(time.Date(2024, 3, 10, 1, 30, 0, 0, location)))
+
+	// 2024-11-03 02:00 EDT falls back to 01:00 EST, 01:30 happens twice
+	schedule, _ = ParseCron("30 1 * * *")
+	first := schedule.Next(time.Date(2024, 11, 3, 0, 0, 0, 0, location))
+	assert.Equal(t, "2024-11-03T01:30:00-04:00", first.Format(time.RFC3339))
+	assert.Equal(t, "2024-11-04T01:30:00-05:00", schedule.Next(first).Format(time.RFC3339))
+}
+
+func TestCron(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Berlin")
+	clock := NewFakeClock(time.Date(2024, 6, 3, 8, 59, 0, 0, location))
+	wheel := NewTimerWheel(time.Second, clock)
+	executor := NewExecutor(0)
+	defer executor.Stop()
+
+	cron := NewCron(executor, wheel, location)
+	assert.NoError(t, cron.Add("hourly", "0 * * * *", 1, nil))
+	assert.NoError(t, cron.Add("report", "0 9 * * mon-fri", 5, nil))
+	assert.NoError(t, cron.Add("removed", "* * * * *", 9, nil))
+	assert.Error(t, cron.Add("invalid", "* * *", 0, nil))
+	assert.True(t, cron.Remove("removed"))
+	assert.False(t, cron.Remove("removed"))
+
+	advance := func(d time.Duration) {
+		for step := time.Duration(0); step < d; step += time.Second {
+			clock.Advance(time.Second)
+			wheel.Poll()
+		}
+	}
+
+	advance(time.Minute)
+	first, _ := executor.scheduler.GetTask()
+	second, _ := executor.scheduler.GetTask()
+	assert.Equal(t, 5, first.Priority)
+	assert.Equal(t, 1, second.Priority)
+
+	advance(time.Hour)
+	assert.Equal(t, 1, executor.scheduler.Len())
+	task, _ := executor.scheduler.GetTask()
+	assert.Equal(t, 1, task.Priority)
+	assert.NotEqual(t, second.Identifier, task.Identifier)
+}
+
+func TestCronSharedExecutor(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 3, 8, 59, 30, 0, time.UTC))
+	wheel := NewTimerWheel(time.Second, clock)
+	executor := NewExecutor(0)
+	defer executor.Stop()
+
+	assert.ErrorIs(t, executor.AddTask(Task{Identifier: -1}), ErrReservedTaskID)
+	assert.NoError(t, executor.AddTask(Task{Identifier: 1, Priority: 3}))
+	assert.NoError(t, NewCron(executor, wheel, time.UTC).Add("first", "* * * * *", 1, nil))
+	assert.NoError(t, NewCron(executor, wheel, time.UTC).Add("second", "* * * * *", 2, nil))
+
+	for step := 0; step < 30; step++ {
+		clock.Advance(time.Second)
+		wheel.Poll()
+	}
+
+	var priorities []int
+	for _, task := range executor.Stop() {
+		priorities = append(priorities, task.Priority)
+	}
+	assert.Equal(t, []int{3, 2, 1}, priorities)
+}
+
+func TestCronLatePoll(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	wheel := NewTimerWheel(time.Second, clock)
+	executor := NewExecutor(0)
+	defer executor.Stop()
+
+	cron := NewCron(executor, wheel, time.UTC)
+	assert.NoError(t, cron.Add("minutely", "* * * * *", 1, nil))
+
+	clock.Advance(3*time.Minute + 30*time.Second)
+	wheel.Poll()
+	assert.Equal(t, 1, executor.scheduler.Len())
+
+	for step := 0; step < 600; step++ {
+		clock.Advance(time.Second)
+		wheel.Poll()
+	}
+	assert.Equal(t, 11, executor.scheduler.Len())
+}
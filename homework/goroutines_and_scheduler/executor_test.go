@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ErrExecutorStopped = errors.New("executor is stopped")
+	ErrReservedTaskID  = errors.New("negative task identifiers are reserved")
+)
+
+// Executor runs tasks of a Scheduler on a fixed number of goroutines in
+// priority order. Tasks are never preempted: a worker picks the next task
+// only after the current one has returned. A panicking task is recovered
+// and counted, so it does not take the worker down.
+type Executor struct {
+	mutex     sync.Mutex
+	scheduler Scheduler
+	added     chan struct{}
+	stopped   bool
+	// internal tasks get negative identifiers, so that they
+	// never replace a task added by the user
+	lastInternalID int
+	panics         atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewExecutor(workersNumber int, options ...Option) *Executor {
+	e := &Executor{
+		scheduler: NewScheduler(options...),
+		added:     make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+
+	e.wg.Add(workersNumber)
+	for i := 0; i < workersNumber; i++ {
+		go e.worker()
+	}
+
+	return e
+}
+
+func (e *Executor) worker() {
+	defer e.wg.Done()
+
+	for {
+		// GetTask returns once the executor is stopped
+		task, err := e.GetTask(context.Background())
+		if err != nil {
+			return
+		}
+		e.run(task)
+	}
+}
+
+func (e *Executor) run(task Task) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.panics.Add(1)
+		}
+	}()
+
+	if task.Run != nil {
+		task.Run()
+	}
+}
+
+// Panics returns the number of tasks that panicked.
+func (e *Executor) Panics() uint64 {
+	return e.panics.Load()
+}
+
+func (e *Executor) AddTask(task Task) error {
+	if task.Identifier < 0 {
+		return ErrReservedTaskID
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.addTaskLocked(task)
+}
+
+// addInternalTask adds a task under a fresh reserved identifier.
+func (e *Executor) addInternalTask(task Task) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.lastInternalID--
+	task.Identifier = e.lastInternalID
+	return e.addTaskLocked(task)
+}
+
+func (e *Executor) addTaskLocked(task Task) error {
+	if e.stopped {
+		return ErrExecutorStopped
+	}
+
+	e.scheduler.AddTask(task)
+	// wake up everyone waiting in GetTask
+	close(e.added)
+	e.added = make(chan struct{})
+	return nil
+}
+
+func (e *Executor) ChangeTaskPriority(taskID int, newPriority int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.scheduler.ChangeTaskPriority(taskID, newPriority)
+}
+
+// GetTask takes the task with the highest priority,
+// waiting for one to be added if the executor is empty.
+func (e *Executor) GetTask(ctx context.Context) (Task, error) {
+	for {
+		e.mutex.Lock()
+		if e.stopped {
+			e.mutex.Unlock()
+			return Task{}, ErrExecutorStopped
+		}
+		if task, ok := e.scheduler.GetTask(); ok {
+			e.mutex.Unlock()
+			return task, nil
+		}
+		added := e.added
+		e.mutex.Unlock()
+
+		select {
+		case <-added:
+		case <-e.stop:
+		case <-ctx.Done():
+			return Task{}, ctx.Err()
+		}
+	}
+}
+
+// Stop waits for the running tasks to complete
+// and returns the tasks that were not started.
+func (e *Executor) Stop() []Task {
+	e.mutex.Lock()
+	if e.stopped {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.stopped = true
+	close(e.stop)
+	e.mutex.Unlock()
+
+	e.wg.Wait()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var pending []Task
+	for {
+		task, ok := e.scheduler.GetTask()
+		if !ok {
+			return pending
+		}
+		pending = append(pending, task)
+	}
+}
+
+func TestExecutorPriorityOrder(t *testing.T) {
+	executor := NewExecutor(1)
+	defer executor.Stop()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	_ = executor.AddTask(Task{Identifier: 0, Run: func() {
+		close(started)
+		<-release
+	}})
+	<-started
+
+	var mutex sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		_ = executor.AddTask(Task{Identifier: i, Priority: i % 3, Run: func() {
+			defer wg.Done()
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, i)
+		}})
+	}
+	executor.ChangeTaskPriority(1, 5)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []int{1, 2, 5, 4, 3}, order)
+}
+
+func TestExecutorBoundedWorkers(t *testing.T) {
+	const workers = 3
+	executor := NewExecutor(workers)
+
+	var running, maxRunning, completed atomic.Int32
+	for i := 0; i < 20; i++ {
+		_ = executor.AddTask(Task{Identifier: i, Run: func() {
+			current := running.Add(1)
+			for {
+				observed := maxRunning.Load()
+				if current <= observed || maxRunning.CompareAndSwap(observed, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			completed.Add(1)
+		}})
+	}
+
+	assert.Eventually(t, func() bool { return completed.Load() == 20 }, time.Second, time.Millisecond)
+	assert.Empty(t, executor.Stop())
+	assert.LessOrEqual(t, maxRunning.Load(), int32(workers))
+}
+
+func TestExecutorGetTaskBlocks(t *testing.T) {
+	executor := NewExecutor(0)
+
+	received := make(chan Task)
+	go func() {
+		task, _ := executor.GetTask(context.Background())
+		received <- task
+	}()
+
+	select {
+	case <-received:
+		t.Fatal("GetTask returned without tasks")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_ = executor.AddTask(Task{Identifier: 1})
+	assert.Equal(t, 1, (<-received).Identifier)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := executor.GetTask(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	executor.Stop()
+	_, err = executor.GetTask(context.Background())
+	assert.ErrorIs(t, err, ErrExecutorStopped)
+}
+
+func TestExecutorPanic(t *testing.T) {
+	executor := NewExecutor(1)
+
+	done := make(chan struct{})
+	_ = executor.AddTask(Task{Identifier: 1, Priority: 2, Run: func() { panic("boom") }})
+	_ = executor.AddTask(Task{Identifier: 2, Priority: 1, Run: func() { close(done) }})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the worker did not survive a panicking task")
+	}
+	assert.Equal(t, uint64(1), executor.Panics())
+	assert.Empty(t, executor.Stop())
+}
+
+func TestExecutorStop(t *testing.T) {
+	executor := NewExecutor(1)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var finished atomic.Bool
+	_ = executor.AddTask(Task{Identifier: 1, Run: func() {
+		close(started)
+		<-release
+		finished.Store(true)
+	}})
+	<-started
+	_ = executor.AddTask(Task{Identifier: 2, Priority: 1})
+	_ = executor.AddTask(Task{Identifier: 3, Priority: 2})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+
+	pending := executor.Stop()
+	assert.True(t, finished.Load())
+	assert.Equal(t, []Task{{Identifier: 3, Priority: 2}, {Identifier: 2, Priority: 1}}, pending)
+	assert.ErrorIs(t, executor.AddTask(Task{Identifier: 4}), ErrExecutorStopped)
+	assert.Nil(t, executor.Stop())
+}
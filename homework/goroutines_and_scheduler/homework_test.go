@@ -1,7 +1,9 @@
 package main
 
 import (
+	"container/heap"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -9,28 +11,208 @@ import (
 type Task struct {
 	Identifier int
 	Priority   int
+	// Run is called when the task is executed by an Executor.
+	Run func()
+	// Deadline is used by the EarliestDeadlineFirst policy.
+	Deadline time.Time
+	// Tenant is used by the WeightedFairQueuing policy.
+	Tenant string
 }
 
+// entry keeps the scheduling priority apart from the task, so that
+// GetTask returns the task exactly as it was added.
+type entry struct {
+	task       Task
+	priority   int
+	rank       float64
+	sequence   uint64
+	enqueuedAt time.Time
+}
+
+func (e *entry) queued() QueuedTask {
+	return QueuedTask{Task: e.task, Priority: e.priority, EnqueuedAt: e.enqueuedAt, Rank: e.rank}
+}
+
+type taskHeap struct {
+	entries    []*entry
+	positions  map[int]int
+	sequence   uint64
+	tieBreaker func(a, b Task) bool
+}
+
+func (h *taskHeap) Len() int {
+	return len(h.entries)
+}
+
+// Less orders entries by rank, then by the tie-breaker
+// and finally by insertion order.
+func (h *taskHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if a.rank != b.rank {
+		return a.rank > b.rank
+	}
+	if h.tieBreaker != nil {
+		if h.tieBreaker(a.task, b.task) {
+			return true
+		}
+		if h.tieBreaker(b.task, a.task) {
+			return false
+		}
+	}
+	return a.sequence < b.sequence
+}
+
+func (h *taskHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.positions[h.entries[i].task.Identifier] = i
+	h.positions[h.entries[j].task.Identifier] = j
+}
+
+func (h *taskHeap) Push(x any) {
+	e := x.(*entry)
+	h.sequence++
+	e.sequence = h.sequence
+	h.positions[e.task.Identifier] = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *taskHeap) Pop() any {
+	last := len(h.entries) - 1
+	e := h.entries[last]
+	h.entries[last] = nil
+	h.entries = h.entries[:last]
+	delete(h.positions, e.task.Identifier)
+	return e
+}
+
+// QueuedTask describes a task in the scheduler to its Policy.
+type QueuedTask struct {
+	Task       Task
+	Priority   int
+	EnqueuedAt time.Time
+	Rank       float64
+}
+
+// Policy decides the order of tasks by ranking them: higher ranks are
+// returned first, equal ranks are ordered by the tie-breaker and then
+// by insertion order.
+type Policy interface {
+	// Enqueue returns the rank of a task being added.
+	Enqueue(task QueuedTask) float64
+	// Reprioritize returns the rank of a task whose priority was changed.
+	Reprioritize(task QueuedTask) float64
+	// Dequeue is called when the task is taken by GetTask.
+	Dequeue(task QueuedTask)
+	// Remove is called when the task leaves the scheduler without being
+	// taken, by Remove or by AddTask replacing it.
+	Remove(task QueuedTask)
+}
+
+type Option func(*Scheduler)
+
+// WithPolicy replaces the default policy that orders tasks by priority.
+func WithPolicy(policy Policy) Option {
+	return func(s *Scheduler) {
+		s.policy = policy
+	}
+}
+
+// WithTieBreaker orders tasks of equal priority by less,
+// tasks it considers equal are still returned in FIFO order.
+func WithTieBreaker(less func(a, b Task) bool) Option {
+	return func(s *Scheduler) {
+		s.heap.tieBreaker = less
+	}
+}
+
+// Scheduler is a max-heap of tasks by priority that keeps the position
+// of every task, so that all operations take O(log n). Tasks of equal
+// priority are returned in the order they were added.
 type Scheduler struct {
-	// need to implement
+	heap      *taskHeap
+	clock     Clock
+	policy    Policy
+	agingRate float64
+	stats     *waitStats
 }
 
-func NewScheduler() Scheduler {
-	// need to implement
-	return Scheduler{}
+func NewScheduler(options ...Option) Scheduler {
+	s := Scheduler{
+		heap:   &taskHeap{positions: make(map[int]int)},
+		clock:  realClock{},
+		policy: &priorityPolicy{},
+		stats:  newWaitStats(nil),
+	}
+	for _, option := range options {
+		option(&s)
+	}
+
+	if s.agingRate != 0 {
+		policy, ok := s.policy.(*priorityPolicy)
+		if !ok {
+			panic("scheduler: WithAging can not be combined with WithPolicy")
+		}
+		policy.agingRate = s.agingRate
+	}
+
+	return s
 }
 
+// AddTask replaces the task with the same identifier if it is already
+// scheduled, the replaced task is queued as if it was added anew.
 func (s *Scheduler) AddTask(task Task) {
-	// need to implement
+	s.Remove(task.Identifier)
+
+	e := &entry{task: task, priority: task.Priority, enqueuedAt: s.clock.Now()}
+	e.rank = s.policy.Enqueue(e.queued())
+	heap.Push(s.heap, e)
 }
 
+// ChangeTaskPriority keeps the insertion order of the task,
+// so it goes before tasks of the new priority added after it.
 func (s *Scheduler) ChangeTaskPriority(taskID int, newPriority int) {
-	// need to implement
+	idx, ok := s.heap.positions[taskID]
+	if !ok {
+		return
+	}
+
+	e := s.heap.entries[idx]
+	e.priority = newPriority
+	e.rank = s.policy.Reprioritize(e.queued())
+	heap.Fix(s.heap, idx)
+}
+
+func (s *Scheduler) GetTask() (Task, bool) {
+	if s.heap.Len() == 0 {
+		return Task{}, false
+	}
+
+	e := heap.Pop(s.heap).(*entry)
+	s.policy.Dequeue(e.queued())
+	s.stats.observe(e.priority, s.clock.Now().Sub(e.enqueuedAt))
+	return e.task, true
+}
+
+func (s *Scheduler) Peek() (Task, bool) {
+	if s.heap.Len() == 0 {
+		return Task{}, false
+	}
+	return s.heap.entries[0].task, true
 }
 
-func (s *Scheduler) GetTask() Task {
-	// need to implement
-	return Task{}
+func (s *Scheduler) Remove(taskID int) bool {
+	idx, ok := s.heap.positions[taskID]
+	if !ok {
+		return false
+	}
+
+	e := heap.Remove(s.heap, idx).(*entry)
+	s.policy.Remove(e.queued())
+	return true
+}
+
+func (s *Scheduler) Len() int {
+	return s.heap.Len()
 }
 
 func TestTrace(t *testing.T) {
@@ -47,17 +229,113 @@ func TestTrace(t *testing.T) {
 	scheduler.AddTask(task4)
 	scheduler.AddTask(task5)
 
-	task := scheduler.GetTask()
+	task, _ := scheduler.GetTask()
 	assert.Equal(t, task5, task)
 
-	task = scheduler.GetTask()
+	task, _ = scheduler.GetTask()
 	assert.Equal(t, task4, task)
 
 	scheduler.ChangeTaskPriority(1, 100)
 
-	task = scheduler.GetTask()
+	task, _ = scheduler.GetTask()
 	assert.Equal(t, task1, task)
 
-	task = scheduler.GetTask()
+	task, _ = scheduler.GetTask()
 	assert.Equal(t, task3, task)
 }
+
+func TestSchedulerEmpty(t *testing.T) {
+	scheduler := NewScheduler()
+
+	_, ok := scheduler.GetTask()
+	assert.False(t, ok)
+	_, ok = scheduler.Peek()
+	assert.False(t, ok)
+	assert.False(t, scheduler.Remove(1))
+	scheduler.ChangeTaskPriority(1, 10)
+	assert.Equal(t, 0, scheduler.Len())
+}
+
+func TestSchedulerPeekAndRemove(t *testing.T) {
+	scheduler := NewScheduler()
+	for i := 1; i <= 5; i++ {
+		scheduler.AddTask(Task{Identifier: i, Priority: i * 10})
+	}
+
+	task, ok := scheduler.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, Task{Identifier: 5, Priority: 50}, task)
+	assert.Equal(t, 5, scheduler.Len())
+
+	assert.True(t, scheduler.Remove(5))
+	assert.True(t, scheduler.Remove(2))
+	assert.False(t, scheduler.Remove(2))
+	assert.Equal(t, 3, scheduler.Len())
+
+	scheduler.ChangeTaskPriority(1, 35)
+
+	var order []int
+	for scheduler.Len() > 0 {
+		task, _ := scheduler.GetTask()
+		order = append(order, task.Identifier)
+	}
+	assert.Equal(t, []int{4, 1, 3}, order)
+}
+
+func TestSchedulerHeapInvariant(t *testing.T) {
+	scheduler := NewScheduler()
+	for i := 0; i < 100; i++ {
+		scheduler.AddTask(Task{Identifier: i, Priority: (i * 37) % 101})
+	}
+	for i := 0; i < 100; i += 3 {
+		scheduler.ChangeTaskPriority(i, (i*53)%97)
+	}
+	for i := 1; i < 100; i += 7 {
+		scheduler.Remove(i)
+	}
+
+	for id, idx := range scheduler.heap.positions {
+		assert.Equal(t, id, scheduler.heap.entries[idx].task.Identifier)
+	}
+
+	previous := heap.Pop(scheduler.heap).(*entry)
+	for scheduler.Len() > 0 {
+		e := heap.Pop(scheduler.heap).(*entry)
+		assert.LessOrEqual(t, e.priority, previous.priority)
+		previous = e
+	}
+}
+
+func TestSchedulerFIFOAmongEqualPriorities(t *testing.T) {
+	scheduler := NewScheduler()
+	for i := 1; i <= 20; i++ {
+		scheduler.AddTask(Task{Identifier: i, Priority: i % 2})
+	}
+
+	scheduler.ChangeTaskPriority(20, 1)
+	scheduler.ChangeTaskPriority(2, 1)
+
+	var order []int
+	for scheduler.Len() > 0 {
+		task, _ := scheduler.GetTask()
+		order = append(order, task.Identifier)
+	}
+	assert.Equal(t, []int{1, 2, 3, 5, 7, 9, 11, 13, 15, 17, 19, 20, 4, 6, 8, 10, 12, 14, 16, 18}, order)
+}
+
+func TestSchedulerTieBreaker(t *testing.T) {
+	scheduler := NewScheduler(WithTieBreaker(func(a, b Task) bool {
+		return a.Identifier%3 < b.Identifier%3
+	}))
+	for i := 1; i <= 6; i++ {
+		scheduler.AddTask(Task{Identifier: i, Priority: 1})
+	}
+	scheduler.AddTask(Task{Identifier: 7, Priority: 2})
+
+	var order []int
+	for scheduler.Len() > 0 {
+		task, _ := scheduler.GetTask()
+		order = append(order, task.Identifier)
+	}
+	assert.Equal(t, []int{7, 3, 6, 1, 4, 2, 5}, order)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type edfPolicy struct {
+	epoch time.Time
+}
+
+// EarliestDeadlineFirst ignores priorities and returns the task with the
+// closest Deadline first, tasks without a deadline go after all others.
+func EarliestDeadlineFirst() Policy {
+	return &edfPolicy{}
+}
+
+func (p *edfPolicy) Enqueue(task QueuedTask) float64 {
+	if task.Task.Deadline.IsZero() {
+		return math.Inf(-1)
+	}
+
+	// ranks are kept relative to the first deadline,
+	// so that float64 does not lose nanoseconds
+	if p.epoch.IsZero() {
+		p.epoch = task.Task.Deadline
+	}
+	return -float64(task.Task.Deadline.Sub(p.epoch))
+}
+
+func (p *edfPolicy) Reprioritize(task QueuedTask) float64 {
+	return task.Rank
+}
+
+func (p *edfPolicy) Dequeue(QueuedTask) {}
+
+func (p *edfPolicy) Remove(QueuedTask) {}
+
+type wfqPolicy struct {
+	weights     map[string]float64
+	virtualTime float64
+	lastFinish  map[string]float64
+}
+
+// WeightedFairQueuing shares the scheduler between tenants in proportion
+// to their weights, tenants missing from weights have weight 1. Each task
+// gets a virtual finish time after the previous task of its tenant and
+// tasks are returned in the order of those times, so a tenant that was
+// idle cannot claim the share it did not use. Priorities are ignored.
+func WeightedFairQueuing(weights map[string]float64) Policy {
+	return &wfqPolicy{
+		weights:    weights,
+		lastFinish: make(map[string]float64),
+	}
+}
+
+func (p *wfqPolicy) cost(tenant string) float64 {
+	if weight, ok := p.weights[tenant]; ok && weight > 0 {
+		return 1 / weight
+	}
+	return 1
+}
+
+func (p *wfqPolicy) Enqueue(task QueuedTask) float64 {
+	tenant := task.Task.Tenant
+	start := math.Max(p.virtualTime, p.lastFinish[tenant])
+	finish := start + p.cost(tenant)
+	p.lastFinish[tenant] = finish
+	return -finish
+}
+
+func (p *wfqPolicy) Reprioritize(task QueuedTask) float64 {
+	return task.Rank
+}
+
+func (p *wfqPolicy) Dequeue(task QueuedTask) {
+	start := -task.Rank - p.cost(task.Task.Tenant)
+	p.virtualTime = math.Max(p.virtualTime, start)
+}
+
+// Remove gives the tenant back the share of a task that never ran, the
+// tasks already queued after it keep their finish times.
+func (p *wfqPolicy) Remove(task QueuedTask) {
+	tenant := task.Task.Tenant
+	p.lastFinish[tenant] = math.Max(p.virtualTime, p.lastFinish[tenant]-p.cost(tenant))
+}
+
+func drain(scheduler *Scheduler) []int {
+	var order []int
+	for scheduler.Len() > 0 {
+		task, _ := scheduler.GetTask()
+		order = append(order, task.Identifier)
+	}
+	return order
+}
+
+func TestEarliestDeadlineFirst(t *testing.T) {
+	now := time.Now()
+	scheduler := NewScheduler(WithPolicy(EarliestDeadlineFirst()))
+
+	scheduler.AddTask(Task{Identifier: 1, Priority: 100, Deadline: now.Add(time.Hour)})
+	scheduler.AddTask(Task{Identifier: 2, Priority: 1000})
+	scheduler.AddTask(Task{Identifier: 3, Deadline: now.Add(time.Minute)})
+	scheduler.AddTask(Task{Identifier: 4, Deadline: now.Add(-time.Minute)})
+	scheduler.AddTask(Task{Identifier: 5, Deadline: now.Add(time.Minute + time.Nanosecond)})
+	scheduler.AddTask(Task{Identifier: 6, Deadline: now.Add(time.Minute)})
+	scheduler.ChangeTaskPriority(1, 1000)
+
+	assert.Equal(t, []int{4, 3, 6, 5, 1, 2}, drain(&scheduler))
+}
+
+func TestWeightedFairQueuing(t *testing.T) {
+	scheduler := NewScheduler(WithPolicy(WeightedFairQueuing(map[string]float64{"a": 2})))
+
+	for i := 0; i < 6; i++ {
+		scheduler.AddTask(Task{Identifier: 10 + i, Tenant: "a"})
+		scheduler.AddTask(Task{Identifier: 20 + i, Tenant: "b"})
+	}
+
+	order := drain(&scheduler)
+	assert.Equal(t, []int{10, 20, 11, 12, 21, 13, 14, 22, 15, 23, 24, 25}, order)
+}
+
+func TestWeightedFairQueuingIdleTenant(t *testing.T) {
+	scheduler := NewScheduler(WithPolicy(WeightedFairQueuing(nil)))
+
+	for i := 0; i < 5; i++ {
+		scheduler.AddTask(Task{Identifier: 10 + i, Tenant: "busy"})
+	}
+	for i := 0; i < 3; i++ {
+		scheduler.GetTask()
+	}
+
+	// the idle tenant does not get credit for the time it was away
+	scheduler.AddTask(Task{Identifier: 20, Tenant: "idle"})
+	scheduler.AddTask(Task{Identifier: 21, Tenant: "idle"})
+	scheduler.AddTask(Task{Identifier: 22, Tenant: "idle"})
+
+	assert.Equal(t, []int{20, 13, 21, 14, 22}, drain(&scheduler))
+}
+
+func TestWeightedFairQueuingRemove(t *testing.T) {
+	scheduler := NewScheduler(WithPolicy(WeightedFairQueuing(nil)))
+
+	for i := 0; i < 3; i++ {
+		scheduler.AddTask(Task{Identifier: 10 + i, Tenant: "a"})
+	}
+	// replacing a task does not charge the tenant twice
+	scheduler.AddTask(Task{Identifier: 12, Tenant: "a"})
+	assert.True(t, scheduler.Remove(11))
+	assert.True(t, scheduler.Remove(12))
+	scheduler.AddTask(Task{Identifier: 20, Tenant: "b"})
+	scheduler.AddTask(Task{Identifier: 21, Tenant: "b"})
+	scheduler.AddTask(Task{Identifier: 22, Tenant: "b"})
+	scheduler.AddTask(Task{Identifier: 13, Tenant: "a"})
+
+	assert.Equal(t, []int{10, 20, 21, 13, 22}, drain(&scheduler))
+}
+
+func TestExecutorWithPolicy(t *testing.T) {
+	executor := NewExecutor(0, WithPolicy(EarliestDeadlineFirst()))
+	defer executor.Stop()
+
+	now := time.Now()
+	_ = executor.AddTask(Task{Identifier: 1, Deadline: now.Add(time.Hour)})
+	_ = executor.AddTask(Task{Identifier: 2, Deadline: now.Add(time.Second)})
+
+	assert.Equal(t, []int{2, 1}, drain(&executor.scheduler))
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+)
+
+type TimerID uint64
+
+type timer struct {
+	id      TimerID
+	expires uint64
+	fn      func()
+	level   int
+	slot    int
+}
+
+// TimerWheel keeps timers in wheelLevels wheels of wheelSize slots, the
+// slots of level n span wheelSize^n ticks each. A timer is put on the
+// lowest level that can hold it and is moved down when its slot on the
+// upper level comes up, so scheduling and cancelling are O(1) no matter
+// how many timers are pending.
+type TimerWheel struct {
+	mutex   sync.Mutex
+	clock   Clock
+	tick    time.Duration
+	start   time.Time
+	current uint64
+	nextID  TimerID
+	timers  map[TimerID]*timer
+	slots   [wheelLevels][wheelSize]map[*timer]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewTimerWheel(tick time.Duration, clock Clock) *TimerWheel {
+	w := &TimerWheel{
+		clock:  clock,
+		tick:   tick,
+		start:  clock.Now(),
+		timers: make(map[TimerID]*timer),
+	}
+	for level := range w.slots {
+		for slot := range w.slots[level] {
+			w.slots[level][slot] = make(map[*timer]struct{})
+		}
+	}
+
+	return w
+}
+
+// Schedule calls fn once delay has passed, rounded up to the tick, a
+// negative delay fires on the next tick. fn runs on the goroutine calling
+// Poll and must not block.
+func (w *TimerWheel) Schedule(delay time.Duration, fn func()) TimerID {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.nextID++
+	t := &timer{id: w.nextID, fn: fn, expires: w.expiration(delay)}
+	w.timers[t.id] = t
+	w.insert(t)
+
+	return t.id
+}
+
+// Cancel reports whether the timer was stopped before firing.
+func (w *TimerWheel) Cancel(id TimerID) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	t, ok := w.timers[id]
+	if !ok {
+		return false
+	}
+
+	w.remove(t)
+	delete(w.timers, id)
+	return true
+}
+
+// Reset reschedules a pending timer to fire after delay from now
+// and reports whether the timer was still pending.
+func (w *TimerWheel) Reset(id TimerID, delay time.Duration) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	t, ok := w.timers[id]
+	if !ok {
+		return false
+	}
+
+	w.remove(t)
+	t.expires = w.expiration(delay)
+	w.insert(t)
+	return true
+}
+
+func (w *TimerWheel) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.timers)
+}
+
+// Poll advances the wheel to the current time of the clock
+// and runs the callbacks of the expired timers.
+func (w *TimerWheel) Poll() {
+	w.mutex.Lock()
+	target := uint64(w.clock.Now().Sub(w.start) / w.tick)
+
+	var expired []*timer
+	for w.current < target {
+		expired = w.advance(expired)
+	}
+	w.mutex.Unlock()
+
+	for _, t := range expired {
+		t.fn()
+	}
+}
+
+// Start polls the wheel every tick until Stop is called.
+func (w *TimerWheel) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.Poll()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop does nothing if the wheel was not started.
+func (w *TimerWheel) Stop() {
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
+
+func (w *TimerWheel) expiration(delay time.Duration) uint64 {
+	ticks := uint64((max(delay, 0) + w.tick - 1) / w.tick)
+	return w.current + max(ticks, 1)
+}
+
+func (w *TimerWheel) insert(t *timer) {
+	delta := t.expires - w.current
+
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+
+	// timers beyond the range of the wheel wait in the farthest slot
+	// of the top level and are reinserted when it is cascaded
+	expires := min(t.expires, w.current+1<<(wheelBits*wheelLevels)-1)
+
+	t.level = level
+	t.slot = int(expires>>(wheelBits*level)) & wheelMask
+	w.slots[t.level][t.slot][t] = struct{}{}
+}
+
+func (w *TimerWheel) remove(t *timer) {
+	delete(w.slots[t.level][t.slot], t)
+}
+
+func (w *TimerWheel) advance(expired []*timer) []*timer {
+	w.current++
+
+	for level := 1; level < wheelLevels; level++ {
+		if w.current&(1<<(wheelBits*level)-1) != 0 {
+			break
+		}
+
+		slot := int(w.current>>(wheelBits*level)) & wheelMask
+		cascaded := w.slots[level][slot]
+		w.slots[level][slot] = make(map[*timer]struct{})
+		for t := range cascaded {
+			w.insert(t)
+		}
+	}
+
+	slot := int(w.current) & wheelMask
+	for t := range w.slots[0][slot] {
+		if t.expires > w.current {
+			continue
+		}
+
+		delete(w.slots[0][slot], t)
+		delete(w.timers, t.id)
+		expired = append(expired, t)
+	}
+
+	return expired
+}
+
+func TestTimerWheelSchedule(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	wheel := NewTimerWheel(time.Millisecond, clock)
+
+	var fired []int
+	wheel.Schedule(3*time.Millisecond, func() { fired = append(fired, 3) })
+	wheel.Schedule(time.Millisecond, func() { fired = append(fired, 1) })
+	wheel.Schedule(1500*time.Microsecond, func() { fired = append(fired, 2) })
+
+	clock.Advance(time.Millisecond)
+	wheel.Poll()
+	assert.Equal(t, []int{1}, fired)
+
+	clock.Advance(time.Millisecond)
+	wheel.Poll()
+	assert.Equal(t, []int{1, 2}, fired)
+
+	clock.Advance(10 * time.Millisecond)
+	wheel.Poll()
+	assert.Equal(t, []int{1, 2, 3}, fired)
+	assert.Equal(t, 0, wheel.Len())
+}
+
+func TestTimerWheelNegativeDelay(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	wheel := NewTimerWheel(time.Millisecond, clock)
+	wheel.Stop()
+
+	var fired atomic.Int32
+	wheel.Schedule(-5*time.Millisecond, func() { fired.Add(1) })
+	id := wheel.Schedule(time.Hour, func() { fired.Add(10) })
+	assert.True(t, wheel.Reset(id, -time.Hour))
+
+	clock.Advance(time.Millisecond)
+	wheel.Poll()
+	assert.Equal(t, int32(11), fired.Load())
+	assert.Equal(t, 0, wheel.Len())
+}
+
+func TestTimerWheelCancelAndReset(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	wheel := NewTimerWheel(time.Millisecond, clock)
+
+	var fired atomic.Int32
+	cancelled := wheel.Schedule(5*time.Millisecond, func() { fired.Add(1) })
+	reset := wheel.Schedule(5*time.Millisecond, func() { fired.Add(10) })
+
+	assert.True(t, wheel.Cancel(cancelled))
+	assert.False(t, wheel.Cancel(cancelled))
+
+	clock.Advance(4 * time.Millisecond)
+	wheel.Poll()
+	assert.True(t, wheel.Reset(reset, 100*time.Millisecond))
+
+	clock.Advance(99 * time.Millisecond)
+	wheel.Poll()
+	assert.Equal(t, int32(0), fired.Load())
+
+	clock.Advance(time.Millisecond)
+	wheel.Poll()
+	assert.Equal(t, int32(10), fired.Load())
+	assert.False(t, wheel.Reset(reset, time.Millisecond))
+}
+
+func TestTimerWheelManyTimers(t *testing.T) {
+	const tick = time.Millisecond
+	start := time.Now()
+	clock := NewFakeClock(start)
+	wheel := NewTimerWheel(tick, clock)
+
+	random := rand.New(rand.NewSource(1))
+	delays := make([]time.Duration, 5000)
+	for i := range delays {
+		// up to the range of the wheel and beyond it
+		delays[i] = time.Duration(random.Int63n(int64(2_000_000 * tick)))
+	}
+	delays = append(delays, time.Duration(1<<(wheelBits*wheelLevels)+12345)*tick)
+
+	firedAt := make([]time.Duration, len(delays))
+	for i, delay := range delays {
+		wheel.Schedule(delay, func() {
+			firedAt[i] = clock.Now().Sub(start)
+		})
+	}
+
+	for wheel.Len() > 0 {
+		clock.Advance(997 * tick)
+		wheel.Poll()
+	}
+
+	for i, delay := range delays {
+		expected := (delay + tick - 1) / tick * tick
+		assert.GreaterOrEqual(t, firedAt[i], expected)
+		assert.Less(t, firedAt[i], expected+997*tick)
+	}
+}
+
+func TestTimerWheelStart(t *testing.T) {
+	wheel := NewTimerWheel(time.Millisecond, realClock{})
+	wheel.Start()
+	defer wheel.Stop()
+
+	fired := make(chan struct{})
+	wheel.Schedule(10*time.Millisecond, func() { close(fired) })
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	opAdd byte = iota + 1
+	opChangePriority
+	opGet
+	opGeneration
+
+	snapshotFile = "snapshot"
+	// record header: payload length and its CRC-32
+	recordHeaderSize = 8
+)
+
+var (
+	errTornRecord      = errors.New("torn record")
+	errCorruptedRecord = errors.New("corrupted record")
+)
+
+type walRecord struct {
+	op         byte
+	id         int64
+	priority   int64
+	generation int64
+	// deadline in Unix nanoseconds, zero for tasks without one
+	deadline int64
+	tenant   string
+}
+
+func addRecord(task Task) walRecord {
+	record := walRecord{op: opAdd, id: int64(task.Identifier), priority: int64(task.Priority), tenant: task.Tenant}
+	if !task.Deadline.IsZero() {
+		record.deadline = task.Deadline.UnixNano()
+	}
+	return record
+}
+
+func (r walRecord) task() Task {
+	task := Task{Identifier: int(r.id), Priority: int(r.priority), Tenant: r.tenant}
+	if r.deadline != 0 {
+		task.Deadline = time.Unix(0, r.deadline)
+	}
+	return task
+}
+
+func (r walRecord) encode() []byte {
+	payload := []byte{r.op}
+	switch r.op {
+	case opAdd:
+		payload = binary.AppendVarint(payload, r.id)
+		payload = binary.AppendVarint(payload, r.priority)
+		payload = binary.AppendVarint(payload, r.deadline)
+		payload = binary.AppendUvarint(payload, uint64(len(r.tenant)))
+		payload = append(payload, r.tenant...)
+	case opChangePriority:
+		payload = binary.AppendVarint(payload, r.id)
+		payload = binary.AppendVarint(payload, r.priority)
+	case opGet:
+		payload = binary.AppendVarint(payload, r.id)
+	case opGeneration:
+		payload = binary.AppendVarint(payload, r.generation)
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// decodeRecord decodes the first record of data, which runs to the end of
+// the file. A bad record is reported as torn only if a crash in the middle
+// of the last append could have left it, any other one is corrupted.
+func decodeRecord(data []byte) (walRecord, int, error) {
+	if len(data) < recordHeaderSize {
+		return walRecord{}, 0, errTornRecord
+	}
+
+	size := int(binary.LittleEndian.Uint32(data))
+	if len(data) < recordHeaderSize+size {
+		return walRecord{}, 0, errTornRecord
+	}
+	if size == 0 {
+		// the file was extended but the record was not written yet
+		if slices.ContainsFunc(data, func(b byte) bool { return b != 0 }) {
+			return walRecord{}, 0, errCorruptedRecord
+		}
+		return walRecord{}, 0, errTornRecord
+	}
+
+	payload := data[recordHeaderSize : recordHeaderSize+size]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:]) {
+		if len(data) == recordHeaderSize+size {
+			return walRecord{}, 0, errTornRecord
+		}
+		return walRecord{}, 0, errCorruptedRecord
+	}
+
+	record := walRecord{op: payload[0]}
+	rest := payload[1:]
+	next := func() int64 {
+		value, n := binary.Varint(rest)
+		if n <= 0 {
+			rest = nil
+			return 0
+		}
+		rest = rest[n:]
+		return value
+	}
+
+	switch record.op {
+	case opAdd:
+		record.id = next()
+		record.priority = next()
+		record.deadline = next()
+		if size, n := binary.Uvarint(rest); n > 0 && uint64(len(rest)-n) >= size {
+			record.tenant = string(rest[n : n+int(size)])
+		}
+	case opChangePriority:
+		record.id = next()
+		record.priority = next()
+	case opGet:
+		record.id = next()
+	case opGeneration:
+		record.generation = next()
+	default:
+		return walRecord{}, 0, fmt.Errorf("unknown wal operation %d", record.op)
+	}
+
+	return record, recordHeaderSize + size, nil
+}
+
+// PersistentScheduler is a Scheduler backed by a write-ahead log in dir.
+// Every operation is appended to the log before it is applied, on open
+// the snapshot and the log are replayed and a torn tail left by a crash
+// is cut off. Tasks are persisted without Run and the aging of recovered
+// tasks starts over. After a compaction WeightedFairQueuing starts its
+// accounting over for the queued tasks.
+type PersistentScheduler struct {
+	scheduler    Scheduler
+	dir          string
+	generation   int64
+	log          *os.File
+	compactEvery int
+	operations   int
+}
+
+// OpenPersistentScheduler compacts the log into a snapshot after every
+// compactEvery operations, zero disables the compaction.
+func OpenPersistentScheduler(dir string, compactEvery int, options ...Option) (*PersistentScheduler, error) {
+	s := &PersistentScheduler{
+		scheduler:    NewScheduler(options...),
+		dir:          dir,
+		compactEvery: compactEvery,
+	}
+
+	if err := s.replaySnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.openLog(); err != nil {
+		return nil, err
+	}
+	if err := s.removeStaleLogs(); err != nil {
+		_ = s.log.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *PersistentScheduler) logPath(generation int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("wal-%d.log", generation))
+}
+
+func (s *PersistentScheduler) replaySnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the snapshot is renamed into place only once fully written,
+	// so unlike the log it can not have a torn tail
+	valid, err := s.replay(data)
+	if err != nil {
+		return err
+	}
+	if valid != len(data) {
+		return fmt.Errorf("corrupted snapshot at offset %d", valid)
+	}
+	return nil
+}
+
+func (s *PersistentScheduler) openLog() error {
+	path := s.logPath(s.generation)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	valid, err := s.replay(data)
+	if err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := log.Truncate(int64(valid)); err != nil {
+		_ = log.Close()
+		return err
+	}
+	if _, err := log.Seek(int64(valid), io.SeekStart); err != nil {
+		_ = log.Close()
+		return err
+	}
+
+	s.log = log
+	return nil
+}
+
+func (s *PersistentScheduler) removeStaleLogs() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "wal-*.log"))
+	if err != nil {
+		return err
+	}
+
+	current := s.logPath(s.generation)
+	for _, path := range paths {
+		if path == current {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay applies the records and returns the length of the valid prefix.
+func (s *PersistentScheduler) replay(data []byte) (int, error) {
+	offset := 0
+	for offset < len(data) {
+		record, n, err := decodeRecord(data[offset:])
+		if errors.Is(err, errTornRecord) {
+			break
+		}
+		if err != nil {
+			return offset, fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+
+		s.apply(record)
+		offset += n
+	}
+
+	return offset, nil
+}
+
+func (s *PersistentScheduler) apply(record walRecord) {
+	switch record.op {
+	case opAdd:
+		s.scheduler.AddTask(record.task())
+	case opChangePriority:
+		s.scheduler.ChangeTaskPriority(int(record.id), int(record.priority))
+	case opGet:
+		// the task is taken the same way as before the crash,
+		// so that the policy sees the same sequence of operations
+		if task, ok := s.scheduler.Peek(); ok && task.Identifier == int(record.id) {
+			s.scheduler.GetTask()
+		} else {
+			s.scheduler.Remove(int(record.id))
+		}
+	case opGeneration:
+		s.generation = record.generation
+	}
+}
+
+func (s *PersistentScheduler) write(record walRecord) error {
+	if _, err := s.log.Write(record.encode()); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *PersistentScheduler) maybeCompact() error {
+	s.operations++
+	if s.compactEvery > 0 && s.operations >= s.compactEvery {
+		return s.Compact()
+	}
+	return nil
+}
+
+func (s *PersistentScheduler) AddTask(task Task) error {
+	if err := s.write(addRecord(task)); err != nil {
+		return err
+	}
+
+	s.scheduler.AddTask(task)
+	return s.maybeCompact()
+}
+
+func (s *PersistentScheduler) ChangeTaskPriority(taskID int, newPriority int) error {
+	if _, ok := s.scheduler.heap.positions[taskID]; !ok {
+		return nil
+	}
+	if err := s.write(walRecord{op: opChangePriority, id: int64(taskID), priority: int64(newPriority)}); err != nil {
+		return err
+	}
+
+	s.scheduler.ChangeTaskPriority(taskID, newPriority)
+	return s.maybeCompact()
+}
+
+// GetTask returns the task even if the compaction that followed it failed.
+func (s *PersistentScheduler) GetTask() (Task, bool, error) {
+	task, ok := s.scheduler.Peek()
+	if !ok {
+		return Task{}, false, nil
+	}
+	if err := s.write(walRecord{op: opGet, id: int64(task.Identifier)}); err != nil {
+		return Task{}, false, err
+	}
+
+	task, _ = s.scheduler.GetTask()
+	return task, true, s.maybeCompact()
+}
+
+func (s *PersistentScheduler) Len() int {
+	return s.scheduler.Len()
+}
+
+// Compact writes the queued tasks into a new snapshot and starts a new
+// log generation. A crash at any point leaves either the old snapshot
+// with the old log or the new snapshot with the new log.
+func (s *PersistentScheduler) Compact() error {
+	entries := slices.Clone(s.scheduler.heap.entries)
+	slices.SortFunc(entries, func(a, b *entry) int {
+		return cmp.Compare(a.sequence, b.sequence)
+	})
+
+	generation := s.generation + 1
+	snapshot := walRecord{op: opGeneration, generation: generation}.encode()
+	for _, e := range entries {
+		snapshot = append(snapshot, addRecord(e.task).encode()...)
+		if e.priority != e.task.Priority {
+			snapshot = append(snapshot, walRecord{op: opChangePriority, id: int64(e.task.Identifier), priority: int64(e.priority)}.encode()...)
+		}
+	}
+
+	// the new log is created before the snapshot that points to it,
+	// so that a failure leaves the scheduler on the old generation
+	log, err := os.OpenFile(s.logPath(generation), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, snapshotFile), snapshot); err != nil {
+		_ = log.Close()
+		_ = os.Remove(s.logPath(generation))
+		return err
+	}
+
+	old := s.log
+	s.log, s.generation, s.operations = log, generation, 0
+	_ = old.Close()
+	// the old log may go only once the rename and the new log are durable,
+	// if it stays behind it is removed on the next open
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	return os.Remove(s.logPath(generation - 1))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+func (s *PersistentScheduler) Close() error {
+	return s.log.Close()
+}
+
+func drainPersistent(t *testing.T, s *PersistentScheduler) []Task {
+	var tasks []Task
+	for {
+		task, ok, err := s.GetTask()
+		assert.NoError(t, err)
+		if !ok {
+			return tasks
+		}
+		tasks = append(tasks, task)
+	}
+}
+
+func TestPersistentSchedulerRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, s.AddTask(Task{Identifier: i, Priority: i % 2}))
+	}
+	assert.NoError(t, s.ChangeTaskPriority(2, 5))
+	assert.NoError(t, s.ChangeTaskPriority(42, 5))
+	task, _, err := s.GetTask()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, task.Identifier)
+	assert.NoError(t, s.Close())
+
+	s, err = OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, []Task{
+		{Identifier: 1, Priority: 1},
+		{Identifier: 3, Priority: 1},
+		{Identifier: 5, Priority: 1},
+		{Identifier: 4, Priority: 0},
+	}, drainPersistent(t, s))
+}
+
+func TestPersistentSchedulerTruncatedWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, s.AddTask(Task{Identifier: 1, Priority: 1}))
+	assert.NoError(t, s.AddTask(Task{Identifier: 2, Priority: 2}))
+	assert.NoError(t, s.Close())
+
+	path := filepath.Join(dir, "wal-0.log")
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	for cut := int64(1); cut < 6; cut++ {
+		assert.NoError(t, os.Truncate(path, info.Size()-cut))
+
+		s, err = OpenPersistentScheduler(dir, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, s.Len())
+
+		// the torn record is cut off, so new records follow valid ones
+		assert.NoError(t, s.AddTask(Task{Identifier: 2, Priority: 2}))
+		assert.NoError(t, s.Close())
+
+		s, err = OpenPersistentScheduler(dir, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, s.Len())
+		assert.NoError(t, s.Close())
+	}
+
+	log, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = log.Write([]byte{0xff, 0, 0, 0, 1, 2, 3, 4, 5})
+	assert.NoError(t, err)
+	assert.NoError(t, log.Close())
+
+	s, err = OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, []Task{{Identifier: 2, Priority: 2}, {Identifier: 1, Priority: 1}}, drainPersistent(t, s))
+}
+
+func TestPersistentSchedulerCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.AddTask(Task{Identifier: i, Priority: i}))
+	}
+	assert.NoError(t, s.Close())
+
+	path := filepath.Join(dir, "wal-0.log")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	// flip a bit in the payload of the second record
+	corrupted := slices.Clone(data)
+	_, n, err := decodeRecord(data)
+	assert.NoError(t, err)
+	corrupted[n+recordHeaderSize+1] ^= 1
+	assert.NoError(t, os.WriteFile(path, corrupted, 0o644))
+
+	_, err = OpenPersistentScheduler(dir, 0)
+	assert.ErrorIs(t, err, errCorruptedRecord)
+
+	// the valid records after it are left in place
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, corrupted, after)
+}
+
+func TestPersistentSchedulerCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenPersistentScheduler(dir, 4)
+	assert.NoError(t, err)
+	for i := 1; i <= 10; i++ {
+		assert.NoError(t, s.AddTask(Task{Identifier: i, Priority: 0}))
+	}
+	assert.NoError(t, s.ChangeTaskPriority(10, 1))
+	_, _, err = s.GetTask()
+	assert.NoError(t, err)
+	assert.NoError(t, s.ChangeTaskPriority(9, 1))
+	assert.NoError(t, s.Close())
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	assert.Equal(t, []string{filepath.Join(dir, "wal-3.log")}, logs)
+
+	// a crash right after the snapshot was written leaves the previous log behind
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "wal-2.log"), walRecord{op: opAdd, id: 100}.encode(), 0o644))
+
+	s, err = OpenPersistentScheduler(dir, 4)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	var ids []int
+	for _, task := range drainPersistent(t, s) {
+		ids = append(ids, task.Identifier)
+	}
+	assert.Equal(t, []int{9, 1, 2, 3, 4, 5, 6, 7, 8}, ids)
+
+	logs, _ = filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	assert.Len(t, logs, 1)
+}
+
+func TestPersistentSchedulerFailedCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, s.AddTask(Task{Identifier: 1, Priority: 1}))
+
+	// the next log generation can not be created
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "wal-1.log"), 0o755))
+	assert.Error(t, s.Compact())
+	assert.NoError(t, s.AddTask(Task{Identifier: 2, Priority: 2}))
+	assert.NoError(t, s.Close())
+
+	s, err = OpenPersistentScheduler(dir, 0)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	var ids []int
+	for _, task := range drainPersistent(t, s) {
+		ids = append(ids, task.Identifier)
+	}
+	assert.Equal(t, []int{2, 1}, ids)
+}
+
+func TestPersistentSchedulerPolicies(t *testing.T) {
+	deadline := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	tasks := []Task{
+		{Identifier: 1, Tenant: "a", Deadline: deadline.Add(3 * time.Hour)},
+		{Identifier: 2, Tenant: "b", Deadline: deadline.Add(time.Hour)},
+		{Identifier: 3, Tenant: "a"},
+		{Identifier: 4, Tenant: "a", Deadline: deadline.Add(2 * time.Hour)},
+		{Identifier: 5, Tenant: "b", Deadline: deadline},
+		{Identifier: 6, Tenant: "a", Deadline: deadline.Add(time.Minute)},
+	}
+
+	policies := map[string]func() Policy{
+		"edf": EarliestDeadlineFirst,
+		"wfq": func() Policy { return WeightedFairQueuing(map[string]float64{"a": 3}) },
+	}
+	for name, policy := range policies {
+		expected := NewScheduler(WithPolicy(policy()))
+		for _, task := range tasks {
+			expected.AddTask(task)
+		}
+		expected.GetTask()
+
+		dir := t.TempDir()
+		s, err := OpenPersistentScheduler(dir, 0, WithPolicy(policy()))
+		assert.NoError(t, err)
+		for _, task := range tasks {
+			assert.NoError(t, s.AddTask(task))
+		}
+		_, _, err = s.GetTask()
+		assert.NoError(t, err)
+		assert.NoError(t, s.Close())
+
+		s, err = OpenPersistentScheduler(dir, 0, WithPolicy(policy()))
+		assert.NoError(t, err)
+		recovered := drainPersistent(t, s)
+		assert.NoError(t, s.Close())
+
+		var ids []int
+		for _, task := range recovered {
+			ids = append(ids, task.Identifier)
+			original := tasks[task.Identifier-1]
+			assert.Equal(t, original.Tenant, task.Tenant, name)
+			assert.True(t, original.Deadline.Equal(task.Deadline), name)
+		}
+		assert.Equal(t, drain(&expected), ids, name)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// globalQueueCheckInterval makes a processor look at the global queue
+	// every so often even if it has local work, as the Go scheduler does
+	globalQueueCheckInterval = 61
+	idleSpins                = 4
+)
+
+var ErrStealingExecutorStopped = errors.New("stealing executor is stopped")
+
+type runQueue struct {
+	mutex sync.Mutex
+	tasks []func()
+}
+
+func (q *runQueue) push(tasks ...func()) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.tasks = append(q.tasks, tasks...)
+}
+
+func (q *runQueue) pop() (func(), bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.tasks) == 0 {
+		return nil, false
+	}
+
+	task := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	return task, true
+}
+
+// grab takes up to n tasks from the head of the queue.
+func (q *runQueue) grab(n int) []func() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	n = min(n, len(q.tasks))
+	grabbed := append([]func(){}, q.tasks[:n]...)
+	clear(q.tasks[:n])
+	q.tasks = q.tasks[n:]
+	return grabbed
+}
+
+func (q *runQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.tasks)
+}
+
+// processor is the P of the G/M/P model: it owns a local run queue,
+// the M is the goroutine running its schedule loop.
+type processor struct {
+	id       int
+	local    runQueue
+	random   *rand.Rand
+	ticks    uint64
+	executed atomic.Uint64
+}
+
+type StealingStats struct {
+	Executed    []uint64
+	Steals      uint64
+	StolenTasks uint64
+	GlobalGrabs uint64
+	IdleSpins   uint64
+	Parks       uint64
+}
+
+// StealingExecutor is a user-space model of the Go scheduler. Submitted
+// tasks go to the global queue, processors move them to their local queues
+// in batches and, when they run out of work, steal half of the local queue
+// of a random victim before parking.
+type StealingExecutor struct {
+	processors []*processor
+	global     runQueue
+
+	// queued counts tasks in all queues, it is changed under idleMutex
+	// when a task is added, so that parking workers never miss a wakeup
+	queued    atomic.Int64
+	idleMutex sync.Mutex
+	idle      *sync.Cond
+	closed    bool
+	stopping  bool
+
+	tasks   sync.WaitGroup
+	workers sync.WaitGroup
+
+	steals      atomic.Uint64
+	stolenTasks atomic.Uint64
+	globalGrabs atomic.Uint64
+	idleSpins   atomic.Uint64
+	parks       atomic.Uint64
+}
+
+func NewStealingExecutor(processorsNumber int) *StealingExecutor {
+	e := &StealingExecutor{processors: make([]*processor, processorsNumber)}
+	e.idle = sync.NewCond(&e.idleMutex)
+
+	for i := range e.processors {
+		e.processors[i] = &processor{id: i, random: rand.New(rand.NewSource(int64(i)))}
+	}
+
+	e.workers.Add(processorsNumber)
+	for _, p := range e.processors {
+		go e.schedule(p)
+	}
+
+	return e
+}
+
+func (e *StealingExecutor) Submit(task func()) error {
+	e.idleMutex.Lock()
+	if e.closed {
+		e.idleMutex.Unlock()
+		return ErrStealingExecutorStopped
+	}
+	e.tasks.Add(1)
+	e.idleMutex.Unlock()
+
+	e.global.push(task)
+	e.added(1)
+	return nil
+}
+
+func (e *StealingExecutor) added(n int) {
+	e.idleMutex.Lock()
+	e.queued.Add(int64(n))
+	e.idleMutex.Unlock()
+	e.idle.Broadcast()
+}
+
+func (e *StealingExecutor) schedule(p *processor) {
+	defer e.workers.Done()
+
+	for {
+		task, ok := e.findRunnable(p)
+		if !ok {
+			return
+		}
+
+		e.queued.Add(-1)
+		task()
+		p.executed.Add(1)
+		e.tasks.Done()
+	}
+}
+
+func (e *StealingExecutor) findRunnable(p *processor) (func(), bool) {
+	for spins := 0; ; spins++ {
+		p.ticks++
+		if p.ticks%globalQueueCheckInterval == 0 {
+			if task, ok := e.global.pop(); ok {
+				return task, true
+			}
+		}
+
+		if task, ok := p.local.pop(); ok {
+			return task, true
+		}
+		if task, ok := e.grabGlobal(p); ok {
+			return task, true
+		}
+		if task, ok := e.steal(p); ok {
+			return task, true
+		}
+
+		if spins < idleSpins {
+			e.idleSpins.Add(1)
+			runtime.Gosched()
+			continue
+		}
+
+		e.idleMutex.Lock()
+		for e.queued.Load() == 0 && !e.stopping {
+			e.parks.Add(1)
+			e.idle.Wait()
+		}
+		stopping := e.stopping && e.queued.Load() == 0
+		e.idleMutex.Unlock()
+
+		if stopping {
+			return nil, false
+		}
+		spins = 0
+	}
+}
+
+// grabGlobal moves a fair share of the global queue to the local one.
+func (e *StealingExecutor) grabGlobal(p *processor) (func(), bool) {
+	n := e.global.len()/len(e.processors) + 1
+	batch := e.global.grab(n)
+	if len(batch) == 0 {
+		return nil, false
+	}
+
+	e.globalGrabs.Add(1)
+	p.local.push(batch[1:]...)
+	return batch[0], true
+}
+
+// steal takes half of the local queue of the first non-empty
+// victim, visiting the other processors from a random one.
+func (e *StealingExecutor) steal(p *processor) (func(), bool) {
+	n := len(e.processors)
+	offset := p.random.Intn(n)
+	for i := 0; i < n; i++ {
+		victim := e.processors[(offset+i)%n]
+		if victim == p {
+			continue
+		}
+
+		stolen := victim.local.grab((victim.local.len() + 1) / 2)
+		if len(stolen) == 0 {
+			continue
+		}
+
+		e.steals.Add(1)
+		e.stolenTasks.Add(uint64(len(stolen)))
+		p.local.push(stolen[1:]...)
+		return stolen[0], true
+	}
+
+	return nil, false
+}
+
+// Shutdown waits for all submitted tasks to complete and stops the processors.
+func (e *StealingExecutor) Shutdown() {
+	e.idleMutex.Lock()
+	e.closed = true
+	e.idleMutex.Unlock()
+
+	e.tasks.Wait()
+
+	e.idleMutex.Lock()
+	e.stopping = true
+	e.idleMutex.Unlock()
+	e.idle.Broadcast()
+
+	e.workers.Wait()
+}
+
+func (e *StealingExecutor) Stats() StealingStats {
+	stats := StealingStats{
+		Executed:    make([]uint64, len(e.processors)),
+		Steals:      e.steals.Load(),
+		StolenTasks: e.stolenTasks.Load(),
+		GlobalGrabs: e.globalGrabs.Load(),
+		IdleSpins:   e.idleSpins.Load(),
+		Parks:       e.parks.Load(),
+	}
+	for i, p := range e.processors {
+		stats.Executed[i] = p.executed.Load()
+	}
+
+	return stats
+}
+
+func TestStealingExecutor(t *testing.T) {
+	executor := NewStealingExecutor(4)
+
+	var counter atomic.Int32
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, executor.Submit(func() { counter.Add(1) }))
+	}
+	executor.Shutdown()
+
+	assert.Equal(t, int32(1000), counter.Load())
+	assert.ErrorIs(t, executor.Submit(func() {}), ErrStealingExecutorStopped)
+
+	var executed uint64
+	for _, n := range executor.Stats().Executed {
+		executed += n
+	}
+	assert.Equal(t, uint64(1000), executed)
+}
+
+func TestStealingExecutorSteals(t *testing.T) {
+	executor := NewStealingExecutor(4)
+
+	// pile all work on one processor, the others can only steal it
+	tasks := make([]func(), 100)
+	for i := range tasks {
+		tasks[i] = func() { time.Sleep(time.Millisecond) }
+	}
+	executor.tasks.Add(len(tasks))
+	executor.processors[0].local.push(tasks...)
+	executor.added(len(tasks))
+	executor.Shutdown()
+
+	stats := executor.Stats()
+	assert.Positive(t, stats.Steals)
+	assert.Positive(t, stats.StolenTasks)
+	for i, executed := range stats.Executed {
+		assert.Positive(t, executed, "processor %d", i)
+	}
+}
+
+func TestStealingExecutorParks(t *testing.T) {
+	executor := NewStealingExecutor(2)
+	assert.Eventually(t, func() bool {
+		return executor.Stats().Parks >= 2
+	}, time.Second, time.Millisecond)
+
+	done := make(chan struct{})
+	_ = executor.Submit(func() { close(done) })
+	<-done
+	executor.Shutdown()
+}
+
+// globalQueuePool is the WorkerPool design: all workers share one channel.
+type globalQueuePool struct {
+	tasks chan func()
+	wg    sync.WaitGroup
+}
+
+func newGlobalQueuePool(workersNumber int) *globalQueuePool {
+	pool := &globalQueuePool{tasks: make(chan func(), 1024)}
+	pool.wg.Add(workersNumber)
+	for i := 0; i < workersNumber; i++ {
+		go func() {
+			defer pool.wg.Done()
+			for task := range pool.tasks {
+				task()
+			}
+		}()
+	}
+
+	return pool
+}
+
+func benchmarkTask(wg *sync.WaitGroup) func() {
+	return func() {
+		sum := 0
+		for i := 0; i < 100; i++ {
+			sum += i
+		}
+		_ = sum
+		wg.Done()
+	}
+}
+
+func BenchmarkStealingExecutor(b *testing.B) {
+	executor := NewStealingExecutor(runtime.GOMAXPROCS(0))
+	defer executor.Shutdown()
+
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = executor.Submit(benchmarkTask(&wg))
+	}
+	wg.Wait()
+}
+
+func BenchmarkGlobalQueuePool(b *testing.B) {
+	pool := newGlobalQueuePool(runtime.GOMAXPROCS(0))
+	defer func() {
+		close(pool.tasks)
+		pool.wg.Wait()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pool.tasks <- benchmarkTask(&wg)
+	}
+	wg.Wait()
+}